@@ -19,11 +19,14 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -31,6 +34,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	"github.com/Nick-Triller/k8s-duplicator/internal/controller"
 	//+kubebuilder:scaffold:imports
@@ -52,12 +56,126 @@ func main() {
 	var enableLeaderElection bool
 	var probeAddr string
 	var leaseId string
+	var dryRun bool
+	var keepDuplicatesInIgnoredNamespaces bool
+	var mode string
+	var sourceNamespaces string
+	var sourceNamespaceSelector string
+	var enableWebhook bool
+	var webhookPort int
+	var webhookCertDir string
+	var webhookAllowedUsernames string
+	var clusterName string
+	var controllerNamespace string
+	var sourceClusters string
+	var resyncPeriod time.Duration
+	var livenessResyncPeriods int
+	var stuckReconcileTimeout time.Duration
+	var maxConcurrentWrites int
+	var kubeAPIQPS float64
+	var kubeAPIBurst int
+	var targetBackoffBase time.Duration
+	var targetBackoffMax time.Duration
+	var targetMaxRetries int
+	var ownershipKeySecret string
+	var adoptUnmarkedDuplicates bool
+	var sourceRecreatePolicy string
+	var workloadRestartQPS float64
+	var workloadRestartBurst int
+	var rolloutBakeTime time.Duration
+	var revisionHistoryLimit int
+	var versionRetention time.Duration
+	var holdInvalidCertificates bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.StringVar(&leaseId, "lease-id", "8f057993", "Lease ID for leader election.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Only log, record events and count metrics for the creates, updates and deletes of duplicates "+
+			"instead of performing them. The writes are validated with server-side dry-run.")
+	flag.BoolVar(&keepDuplicatesInIgnoredNamespaces, "keep-duplicates-in-ignored-namespaces", false,
+		"Keep existing duplicates in namespaces that opted out of receiving duplicates instead of deleting them.")
+	flag.StringVar(&mode, "mode", string(controller.ModePush),
+		"Decides which namespaces receive duplicates. "+
+			"\"push\" duplicates source secrets into all namespaces, "+
+			"\"pull\" only into namespaces that request them with the pull annotation.")
+	flag.StringVar(&sourceNamespaces, "source-namespaces", "",
+		"Comma separated list of namespaces that are allowed to host source secrets. "+
+			"All namespaces are allowed if neither -source-namespaces nor -source-namespace-selector is set.")
+	flag.StringVar(&sourceNamespaceSelector, "source-namespace-selector", "",
+		"Label selector for namespaces that are allowed to host source secrets, e.g. \"duplicator-sources=true\".")
+	flag.BoolVar(&enableWebhook, "enable-webhook", false,
+		"Enable the validating admission webhook that protects duplicates from manual edits.")
+	flag.IntVar(&webhookPort, "webhook-port", 9443, "The port the webhook server binds to.")
+	flag.StringVar(&webhookCertDir, "webhook-cert-dir", "",
+		"The directory that contains the webhook server certificate tls.crt and key tls.key. "+
+			"Defaults to <temp-dir>/k8s-webhook-server/serving-certs.")
+	flag.StringVar(&webhookAllowedUsernames, "webhook-allowed-usernames", "",
+		"Comma separated list of users that may change and delete duplicates, "+
+			"e.g. system:serviceaccount:k8s-duplicator:k8s-duplicator. "+
+			"The namespace controller and garbage collector are always allowed.")
+	flag.StringVar(&clusterName, "cluster-name", "",
+		"Name of this cluster. It must be unique among the clusters that sync duplicates into the same remote cluster, "+
+			"and it is required to sync duplicates into remote clusters.")
+	flag.StringVar(&controllerNamespace, "controller-namespace", "",
+		"Namespace of the controller that contains the ownership key secret and the kubeconfig secrets of remote clusters. "+
+			"Defaults to the namespace of the service account of the controller, or \"default\" outside of a pod.")
+	flag.StringVar(&sourceClusters, "source-clusters", "",
+		"Comma separated list of kubeconfig secrets of remote clusters whose source secrets "+
+			"are duplicated into this cluster.")
+	flag.DurationVar(&resyncPeriod, "resync-period", 0,
+		"Interval of periodic full reconciles, e.g. 10m. Periodic full reconciles are disabled by default. "+
+			"If set, the leader reports unready when no full reconcile completed within two resync periods.")
+	flag.IntVar(&livenessResyncPeriods, "liveness-resync-periods", 3,
+		"Number of resync periods without a completed reconcile after which the leader reports not live. "+
+			"Requires -resync-period. 0 disables the check.")
+	flag.DurationVar(&stuckReconcileTimeout, "stuck-reconcile-timeout", 15*time.Minute,
+		"Duration without a completed write after which a running reconcile is considered stuck "+
+			"and the controller reports not live. Must be well above the duration of a write at -kube-api-qps. "+
+			"0 disables the check.")
+	flag.IntVar(&maxConcurrentWrites, "max-concurrent-writes", 5,
+		"Maximum number of concurrent reads and writes of duplicates within a reconcile.")
+	flag.Float64Var(&kubeAPIQPS, "kube-api-qps", 20,
+		"Maximum queries per second to the Kubernetes API server of each cluster.")
+	flag.IntVar(&kubeAPIBurst, "kube-api-burst", 30,
+		"Maximum burst of queries to the Kubernetes API server of each cluster.")
+	flag.DurationVar(&targetBackoffBase, "target-backoff-base", time.Second,
+		"Delay before retrying a failed write of a duplicate. The delay doubles with each failure.")
+	flag.DurationVar(&targetBackoffMax, "target-backoff-max", 5*time.Minute,
+		"Maximum delay before retrying a failed write of a duplicate.")
+	flag.IntVar(&targetMaxRetries, "target-max-retries", 0,
+		"Number of failed writes of a duplicate after which it isn't retried until the source secret changes. "+
+			"0 retries forever.")
+	flag.StringVar(&ownershipKeySecret, "ownership-key-secret", "k8s-duplicator-ownership-key",
+		"Name of the secret in the controller namespace that contains the key to sign duplicates with. "+
+			"It is created if it doesn't exist. "+
+			"Only duplicates with a valid signature are updated and deleted. "+
+			"If the secret can't be loaded or created, no duplicates are synced until it can.")
+	flag.StringVar(&sourceRecreatePolicy, "source-recreate-policy", string(controller.SourceRecreatePolicyUpdate),
+		"Decides what happens to the duplicates of a source secret that was deleted and recreated with the same name. "+
+			"\"update\" updates them, \"recreate\" deletes and creates them again, \"reject\" leaves them untouched.")
+	flag.Float64Var(&workloadRestartQPS, "workload-restart-qps", 1,
+		"Maximum restarts per second of workloads that consume duplicates of source secrets "+
+			"with the restart-workloads annotation.")
+	flag.IntVar(&workloadRestartBurst, "workload-restart-burst", 10,
+		"Maximum burst of restarts of workloads that consume duplicates.")
+	flag.DurationVar(&rolloutBakeTime, "rollout-bake-time", 10*time.Minute,
+		"Time a staged rollout waits after the duplicates of a wave were updated before proceeding to the next wave.")
+	flag.IntVar(&revisionHistoryLimit, "revision-history-limit", 0,
+		"Number of revisions of each source secret that are kept in a history secret in the controller namespace, "+
+			"so duplicates can be pinned to a previous revision with the pin-revision annotation. "+
+			"Disabled if zero, e.g. 10 keeps the last 10 revisions.")
+	flag.DurationVar(&versionRetention, "version-retention", 24*time.Hour,
+		"Time after which superseded versioned copies of source secrets with the versioned-names annotation are deleted.")
+	flag.BoolVar(&holdInvalidCertificates, "hold-invalid-certificates", false,
+		"Keep the duplicates of TLS source secrets at their current content while the certificate of the source secret "+
+			"doesn't parse, doesn't match the key or is expired. Otherwise, invalid certificates are only reported.")
+	flag.BoolVar(&adoptUnmarkedDuplicates, "adopt-unmarked-duplicates", false,
+		"Take over duplicates without ownership marker, e.g. duplicates created by earlier versions. "+
+			"Anyone who can annotate a secret can make an unmarked secret look like a duplicate, "+
+			"so only enable this flag temporarily after an upgrade.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,7 +183,50 @@ func main() {
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	if mode != string(controller.ModePush) && mode != string(controller.ModePull) {
+		setupLog.Error(nil, "invalid mode, must be push or pull", "mode", mode)
+		os.Exit(1)
+	}
+	switch controller.SourceRecreatePolicy(sourceRecreatePolicy) {
+	case controller.SourceRecreatePolicyUpdate, controller.SourceRecreatePolicyRecreate,
+		controller.SourceRecreatePolicyReject:
+	default:
+		setupLog.Error(nil, "invalid source recreate policy, must be update, recreate or reject",
+			"sourceRecreatePolicy", sourceRecreatePolicy)
+		os.Exit(1)
+	}
+	if strings.Contains(clusterName, "/") {
+		setupLog.Error(nil, "invalid cluster name, must not contain a slash", "clusterName", clusterName)
+		os.Exit(1)
+	}
+	if controllerNamespace == "" {
+		controllerNamespace = defaultControllerNamespace()
+	}
+	var sourceNamespaceList []string
+	for _, namespace := range strings.Split(sourceNamespaces, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			sourceNamespaceList = append(sourceNamespaceList, namespace)
+		}
+	}
+	var sourceClusterList []string
+	for _, sourceCluster := range strings.Split(sourceClusters, ",") {
+		if sourceCluster = strings.TrimSpace(sourceCluster); sourceCluster != "" {
+			sourceClusterList = append(sourceClusterList, sourceCluster)
+		}
+	}
+	var sourceNamespaceLabelSelector labels.Selector
+	if sourceNamespaceSelector != "" {
+		selector, err := labels.Parse(sourceNamespaceSelector)
+		if err != nil {
+			setupLog.Error(err, "invalid source namespace selector")
+			os.Exit(1)
+		}
+		sourceNamespaceLabelSelector = selector
+	}
+	restConfig := ctrl.GetConfigOrDie()
+	restConfig.QPS = float32(kubeAPIQPS)
+	restConfig.Burst = kubeAPIBurst
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
 		Scheme:                 scheme,
 		Metrics:                metricsserver.Options{BindAddress: metricsAddr},
 		HealthProbeBindAddress: probeAddr,
@@ -82,29 +243,94 @@ func main() {
 		// if you are doing or is intended to do any operation such as perform cleanups
 		// after the manager stops then its usage might be unsafe.
 		LeaderElectionReleaseOnCancel: true,
+		WebhookServer: webhook.NewServer(webhook.Options{
+			Port:    webhookPort,
+			CertDir: webhookCertDir,
+		}),
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
-	if err = (&controller.SecretReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
+	secretReconciler := &controller.SecretReconciler{
+		Client:                            mgr.GetClient(),
+		Scheme:                            mgr.GetScheme(),
+		Recorder:                          mgr.GetEventRecorderFor("k8s-duplicator"),
+		DryRun:                            dryRun,
+		Mode:                              controller.Mode(mode),
+		SourceRecreatePolicy:              controller.SourceRecreatePolicy(sourceRecreatePolicy),
+		SourceNamespaces:                  sourceNamespaceList,
+		SourceNamespaceSelector:           sourceNamespaceLabelSelector,
+		KeepDuplicatesInIgnoredNamespaces: keepDuplicatesInIgnoredNamespaces,
+		ClusterName:                       clusterName,
+		ControllerNamespace:               controllerNamespace,
+		SourceClusters:                    sourceClusterList,
+		ResyncPeriod:                      resyncPeriod,
+		LivenessResyncPeriods:             livenessResyncPeriods,
+		StuckReconcileTimeout:             stuckReconcileTimeout,
+		MaxConcurrentWrites:               maxConcurrentWrites,
+		TargetBackoffBase:                 targetBackoffBase,
+		TargetBackoffMax:                  targetBackoffMax,
+		TargetMaxRetries:                  targetMaxRetries,
+		OwnershipKeySecret:                ownershipKeySecret,
+		AdoptUnmarkedDuplicates:           adoptUnmarkedDuplicates,
+		WorkloadRestartQPS:                float32(workloadRestartQPS),
+		WorkloadRestartBurst:              workloadRestartBurst,
+		RolloutBakeTime:                   rolloutBakeTime,
+		RevisionHistoryLimit:              revisionHistoryLimit,
+		VersionRetention:                  versionRetention,
+		HoldInvalidCertificates:           holdInvalidCertificates,
+	}
+	if err = secretReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Secret")
 		os.Exit(1)
 	}
+	if enableWebhook {
+		allowedUsernames := []string{
+			"system:serviceaccount:kube-system:namespace-controller",
+			"system:serviceaccount:kube-system:generic-garbage-collector",
+		}
+		for _, username := range strings.Split(webhookAllowedUsernames, ",") {
+			if username = strings.TrimSpace(username); username != "" {
+				allowedUsernames = append(allowedUsernames, username)
+			}
+		}
+		if err = (&controller.SecretValidator{
+			AllowedUsernames: allowedUsernames,
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Secret")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
+	if err := mgr.AddHealthzCheck("reconcile", secretReconciler.LivenessChecker); err != nil {
+		setupLog.Error(err, "unable to set up reconcile health check")
+		os.Exit(1)
+	}
 	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if err := mgr.AddReadyzCheck("cache", controller.CacheSyncChecker(mgr.GetCache())); err != nil {
+		setupLog.Error(err, "unable to set up cache ready check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("resync", secretReconciler.ResyncChecker); err != nil {
+		setupLog.Error(err, "unable to set up resync ready check")
+		os.Exit(1)
+	}
+	if enableWebhook {
+		if err := mgr.AddReadyzCheck("webhook", mgr.GetWebhookServer().StartedChecker()); err != nil {
+			setupLog.Error(err, "unable to set up webhook ready check")
+			os.Exit(1)
+		}
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -112,3 +338,13 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// defaultControllerNamespace returns the namespace of the service account of the controller pod,
+// or "default" outside of a pod.
+func defaultControllerNamespace() string {
+	namespace, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if err != nil || len(strings.TrimSpace(string(namespace))) == 0 {
+		return "default"
+	}
+	return strings.TrimSpace(string(namespace))
+}
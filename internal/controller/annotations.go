@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// secretAnnotationValidators contains the known duplicator annotations of secrets and validates their values.
+// Annotations that are managed by the controller have no validator.
+var secretAnnotationValidators = map[string]func(value string) error{
+	duplicatorDuplicateAnnotationKey:             validateBool,
+	duplicatorPausedAnnotationKey:                validateBool,
+	duplicatorPullAllowedAnnotationKey:           validateBool,
+	duplicatorPullAllowedNamespacesAnnotationKey: validateNamespaceList,
+	duplicatorClustersAnnotationKey:              validateClusterList,
+	duplicatorRestartWorkloadsAnnotationKey:      validateBool,
+	duplicatorStagedRolloutAnnotationKey:         validateBool,
+	duplicatorPinRevisionAnnotationKey:           validateRevision,
+	duplicatorImmutableAnnotationKey:             validateBool,
+	duplicatorVersionedNamesAnnotationKey:        validateBool,
+	duplicatorAcceptRecreatedFromAnnotationKey:   validateUID,
+	duplicatorFromAnnotationKey:                  nil,
+	duplicatorStatusAnnotationKey:                nil,
+	duplicatorSignatureAnnotationKey:             nil,
+	duplicatorSourceUIDAnnotationKey:             nil,
+	duplicatorSourceResourceVersionAnnotationKey: nil,
+	duplicatorContentHashAnnotationKey:           nil,
+	duplicatorHistoryOfAnnotationKey:             nil,
+	duplicatorSupersededAtAnnotationKey:          nil,
+	duplicatorRestartPendingAnnotationKey:        nil,
+}
+
+// validateSecretAnnotations parses all known duplicator annotations of secret.
+// The returned error lists every invalid value and invalid combination of annotations.
+// The reconciler and the webhook both use this function so they never disagree about a source secret.
+// Unknown annotations aren't errors, see unknownSecretAnnotations.
+func validateSecretAnnotations(secret *corev1.Secret) error {
+	keys := make([]string, 0, len(secret.Annotations))
+	for key := range secret.Annotations {
+		if strings.HasPrefix(key, duplicatorAnnotationPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		validate, known := secretAnnotationValidators[key]
+		if !known || validate == nil {
+			continue
+		}
+		if err := validate(secret.Annotations[key]); err != nil {
+			errs = append(errs, fmt.Errorf("annotation %s: %w", key, err))
+		}
+	}
+
+	_, hasPullAllowedNamespaces := secret.Annotations[duplicatorPullAllowedNamespacesAnnotationKey]
+	if secret.Annotations[duplicatorPullAllowedAnnotationKey] == "true" && hasPullAllowedNamespaces {
+		errs = append(errs, fmt.Errorf("annotations %s and %s are mutually exclusive, "+
+			"remove %s to allow all namespaces to pull the secret",
+			duplicatorPullAllowedAnnotationKey, duplicatorPullAllowedNamespacesAnnotationKey,
+			duplicatorPullAllowedNamespacesAnnotationKey))
+	}
+
+	if isStagedRollout(secret) && secret.Annotations[duplicatorClustersAnnotationKey] != "" {
+		errs = append(errs, fmt.Errorf("annotations %s and %s are mutually exclusive, "+
+			"because duplicates in remote clusters aren't rolled out in waves",
+			duplicatorStagedRolloutAnnotationKey, duplicatorClustersAnnotationKey))
+	}
+
+	return errors.Join(errs...)
+}
+
+// unknownSecretAnnotations returns the sorted duplicator annotations of secret that aren't known.
+// They are only reported, because they may have been added for a newer version of the controller,
+// e.g. during a rollout or rollback of the controller.
+func unknownSecretAnnotations(secret *corev1.Secret) []string {
+	var unknown []string
+	for key := range secret.Annotations {
+		if _, known := secretAnnotationValidators[key]; strings.HasPrefix(key, duplicatorAnnotationPrefix) && !known {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+func validateBool(value string) error {
+	if value != "true" && value != "false" {
+		return fmt.Errorf("invalid value %q, must be \"true\" or \"false\"", value)
+	}
+	return nil
+}
+
+func validateRevision(value string) error {
+	if len(value) < minRevisionPrefix || len(value) > sha256.Size*2 {
+		return fmt.Errorf("invalid revision %q, must have between %d and %d characters",
+			value, minRevisionPrefix, sha256.Size*2)
+	}
+	if strings.Trim(value, "0123456789abcdef") != "" {
+		return fmt.Errorf("invalid revision %q, must be a lowercase hex string", value)
+	}
+	return nil
+}
+
+func validateUID(value string) error {
+	if value == "" {
+		return errors.New("UID must not be empty")
+	}
+	return nil
+}
+
+func validateNamespaceList(value string) error {
+	var errs []error
+	for _, namespace := range splitList(value) {
+		if msgs := validation.IsDNS1123Label(namespace); len(msgs) > 0 {
+			errs = append(errs, fmt.Errorf("invalid namespace %q: %s", namespace, strings.Join(msgs, ", ")))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateClusterList(value string) error {
+	var errs []error
+	for _, cluster := range splitList(value) {
+		if msgs := validation.IsDNS1123Subdomain(cluster); len(msgs) > 0 {
+			errs = append(errs, fmt.Errorf("invalid kubeconfig secret name %q: %s", cluster, strings.Join(msgs, ", ")))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// splitList splits a comma separated list and drops empty elements.
+func splitList(value string) []string {
+	var elements []string
+	for _, element := range strings.Split(value, ",") {
+		element = strings.TrimSpace(element)
+		if element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_validateSecretAnnotations(t *testing.T) {
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		wantErr     string
+	}{
+		{
+			name:        "no annotations",
+			annotations: nil,
+		},
+		{
+			name: "valid source",
+			annotations: map[string]string{
+				duplicatorDuplicateAnnotationKey:             "true",
+				duplicatorPausedAnnotationKey:                "false",
+				duplicatorPullAllowedNamespacesAnnotationKey: "ns1, ns2",
+				duplicatorClustersAnnotationKey:              "edge-1,edge.2",
+				duplicatorStatusAnnotationKey:                "{}",
+				"unrelated.example.com/annotation":           "anything",
+			},
+		},
+		{
+			name: "valid duplicate",
+			annotations: map[string]string{
+				duplicatorFromAnnotationKey: "ns/secret1",
+			},
+		},
+		{
+			name: "invalid bool",
+			annotations: map[string]string{
+				duplicatorDuplicateAnnotationKey: "yes",
+			},
+			wantErr: `annotation duplicator.k8s.nicktriller.com/duplicate: invalid value "yes", must be "true" or "false"`,
+		},
+		{
+			name: "invalid namespace",
+			annotations: map[string]string{
+				duplicatorPullAllowedNamespacesAnnotationKey: "ns1,Ns_2",
+			},
+			wantErr: `annotation duplicator.k8s.nicktriller.com/pull-allowed-namespaces: invalid namespace "Ns_2": ` +
+				`a lowercase RFC 1123 label must consist of lower case alphanumeric characters or '-', ` +
+				`and must start and end with an alphanumeric character ` +
+				`(e.g. 'my-name',  or '123-abc', regex used for validation is '[a-z0-9]([-a-z0-9]*[a-z0-9])?')`,
+		},
+		{
+			name: "invalid cluster",
+			annotations: map[string]string{
+				duplicatorClustersAnnotationKey: "edge_1",
+			},
+			wantErr: `annotation duplicator.k8s.nicktriller.com/clusters: invalid kubeconfig secret name "edge_1": ` +
+				`a lowercase RFC 1123 subdomain must consist of lower case alphanumeric characters, '-' or '.', ` +
+				`and must start and end with an alphanumeric character ` +
+				`(e.g. 'example.com', regex used for validation is ` +
+				`'[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*')`,
+		},
+		{
+			name: "invalid revision",
+			annotations: map[string]string{
+				duplicatorPinRevisionAnnotationKey: "ABCDEF01",
+			},
+			wantErr: `annotation duplicator.k8s.nicktriller.com/pin-revision: invalid revision "ABCDEF01", ` +
+				`must be a lowercase hex string`,
+		},
+		{
+			name: "unknown annotation and invalid bool",
+			annotations: map[string]string{
+				duplicatorAnnotationPrefix + "unknown": "true",
+				duplicatorPausedAnnotationKey:          "True",
+			},
+			wantErr: "annotation duplicator.k8s.nicktriller.com/paused: invalid value \"True\", must be \"true\" or \"false\"",
+		},
+		{
+			name: "mutually exclusive annotations",
+			annotations: map[string]string{
+				duplicatorPullAllowedAnnotationKey:           "true",
+				duplicatorPullAllowedNamespacesAnnotationKey: "ns1",
+			},
+			wantErr: "annotations duplicator.k8s.nicktriller.com/pull-allowed and " +
+				"duplicator.k8s.nicktriller.com/pull-allowed-namespaces are mutually exclusive, " +
+				"remove duplicator.k8s.nicktriller.com/pull-allowed-namespaces to allow all namespaces to pull the secret",
+		},
+		{
+			name: "staged rollout to remote clusters",
+			annotations: map[string]string{
+				duplicatorStagedRolloutAnnotationKey: "true",
+				duplicatorClustersAnnotationKey:      "edge-1",
+			},
+			wantErr: "annotations duplicator.k8s.nicktriller.com/staged-rollout and " +
+				"duplicator.k8s.nicktriller.com/clusters are mutually exclusive, " +
+				"because duplicates in remote clusters aren't rolled out in waves",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "secret1",
+					Namespace:   "ns",
+					Annotations: tc.annotations,
+				},
+			}
+			err := validateSecretAnnotations(secret)
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if gotErr != tc.wantErr {
+				t.Errorf("got error %q, wanted %q", gotErr, tc.wantErr)
+			}
+		})
+	}
+}
+
+func Test_unknownSecretAnnotations(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				duplicatorAnnotationPrefix + "newer":   "true",
+				duplicatorAnnotationPrefix + "another": "x",
+				duplicatorDuplicateAnnotationKey:       "true",
+				"unrelated.example.com/annotation":     "anything",
+			},
+		},
+	}
+	got := unknownSecretAnnotations(secret)
+	want := []string{duplicatorAnnotationPrefix + "another", duplicatorAnnotationPrefix + "newer"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+}
@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultTargetBackoffBase = time.Second
+	defaultTargetBackoffMax  = 5 * time.Minute
+)
+
+// targetKey identifies the duplicate of a source secret in a namespace of a cluster.
+type targetKey struct {
+	// cluster is the name of the kubeconfig secret of a remote cluster, empty for the local cluster
+	cluster string
+	// source is the source annotation of the duplicate without the prefix of the pushing cluster
+	source    string
+	namespace string
+	// version is the name of a versioned copy, empty for the duplicate with the name of the source secret
+	version string
+}
+
+// statusKey returns the key of the target in the status annotation of its source secret, the namespace
+// or the namespace and name of a versioned copy, prefixed with the name of the kubeconfig secret for remote clusters.
+func (k targetKey) statusKey() string {
+	key := k.namespace
+	if k.version != "" {
+		key += "/" + k.version
+	}
+	if k.cluster != "" {
+		key = k.cluster + "/" + key
+	}
+	return key
+}
+
+type targetState struct {
+	failures    int
+	nextAttempt time.Time
+	lastError   error
+	// sourceVersion is the content hash of the source secret at the last failure
+	sourceVersion string
+	// gaveUp is true if the target failed with a terminal error or reached the maximum number of retries
+	gaveUp   bool
+	lastSeen time.Time
+}
+
+// targetBackoff tracks failed writes of duplicates, so that each target is retried with its own exponential backoff
+// instead of retrying the whole reconcile. The zero value is ready to use.
+type targetBackoff struct {
+	mu      sync.Mutex
+	targets map[targetKey]*targetState
+}
+
+// ready returns true if the target may be written at now. A failed target becomes ready again when its backoff
+// expired and it didn't give up, or when the source secret changed since the last failure.
+func (b *targetBackoff) ready(key targetKey, sourceVersion string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state, ok := b.targets[key]
+	if !ok {
+		return true
+	}
+	state.lastSeen = now
+	if state.sourceVersion != sourceVersion {
+		return true
+	}
+	return !state.gaveUp && !now.Before(state.nextAttempt)
+}
+
+// succeeded forgets the failures of the target.
+func (b *targetBackoff) succeeded(key targetKey) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.targets[key]; ok {
+		delete(b.targets, key)
+		targetFailuresGauge.DeleteLabelValues(key.cluster, key.source, key.namespace, key.version)
+	}
+}
+
+// failed records a failure of the target and schedules the next attempt.
+// The backoff starts at base and doubles with each failure up to maxDelay.
+// The target gives up if err is terminal or after maxRetries failures, unless maxRetries is zero.
+func (b *targetBackoff) failed(key targetKey, sourceVersion string, err error, now time.Time,
+	base, maxDelay time.Duration, maxRetries int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.targets == nil {
+		b.targets = make(map[targetKey]*targetState)
+	}
+	state, ok := b.targets[key]
+	if !ok || state.sourceVersion != sourceVersion {
+		state = &targetState{sourceVersion: sourceVersion}
+		b.targets[key] = state
+	}
+	state.failures++
+	state.lastError = err
+	state.lastSeen = now
+	delay := maxDelay
+	if state.failures < 32 {
+		delay = min(base<<(state.failures-1), maxDelay)
+	}
+	state.nextAttempt = now.Add(delay)
+	state.gaveUp = isTerminalError(err) || (maxRetries > 0 && state.failures >= maxRetries)
+	targetFailuresGauge.WithLabelValues(key.cluster, key.source, key.namespace, key.version).Set(float64(state.failures))
+}
+
+// nextRetry returns the delay until the next attempt of a failed target that didn't give up,
+// or zero if there is none.
+func (b *targetBackoff) nextRetry(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var next time.Duration
+	for _, state := range b.targets {
+		if state.gaveUp {
+			continue
+		}
+		delay := max(state.nextAttempt.Sub(now), time.Millisecond)
+		if next == 0 || delay < next {
+			next = delay
+		}
+	}
+	return next
+}
+
+// prune forgets the targets that weren't seen since before, e.g. because the source secret
+// or the namespace was deleted.
+func (b *targetBackoff) prune(before time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, state := range b.targets {
+		if state.lastSeen.Before(before) {
+			delete(b.targets, key)
+			targetFailuresGauge.DeleteLabelValues(key.cluster, key.source, key.namespace, key.version)
+		}
+	}
+}
+
+// failing returns a copy of the states of the failed targets.
+func (b *targetBackoff) failing() map[targetKey]targetState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	failing := make(map[targetKey]targetState, len(b.targets))
+	for key, state := range b.targets {
+		failing[key] = *state
+	}
+	return failing
+}
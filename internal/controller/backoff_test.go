@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_targetBackoff(t *testing.T) {
+	key := targetKey{source: "ns/secret1", namespace: "ns2"}
+	now := time.Unix(0, 0)
+	errTransient := errors.New("transient")
+
+	testCases := []struct {
+		name       string
+		failures   int
+		err        error
+		maxRetries int
+		// at is the time of the attempt after the last failure
+		at            time.Duration
+		sourceVersion string
+		wantReady     bool
+		wantNextRetry time.Duration
+	}{
+		{
+			name:          "never failed",
+			sourceVersion: "v1",
+			wantReady:     true,
+		},
+		{
+			name:          "backing off after first failure",
+			failures:      1,
+			err:           errTransient,
+			at:            500 * time.Millisecond,
+			sourceVersion: "v1",
+			wantNextRetry: 500 * time.Millisecond,
+		},
+		{
+			name:          "backoff expired after first failure",
+			failures:      1,
+			err:           errTransient,
+			at:            time.Second,
+			sourceVersion: "v1",
+			wantReady:     true,
+			wantNextRetry: time.Millisecond,
+		},
+		{
+			name:          "backoff doubles",
+			failures:      3,
+			err:           errTransient,
+			at:            3 * time.Second,
+			sourceVersion: "v1",
+			wantNextRetry: time.Second,
+		},
+		{
+			name:          "backoff is capped",
+			failures:      10,
+			err:           errTransient,
+			at:            9 * time.Second,
+			sourceVersion: "v1",
+			wantNextRetry: time.Second,
+		},
+		{
+			name:          "source changed",
+			failures:      3,
+			err:           errTransient,
+			sourceVersion: "v2",
+			wantReady:     true,
+			wantNextRetry: 4 * time.Second,
+		},
+		{
+			name:          "gave up after max retries",
+			failures:      3,
+			err:           errTransient,
+			maxRetries:    3,
+			at:            time.Hour,
+			sourceVersion: "v1",
+		},
+		{
+			name:          "gave up after terminal error",
+			failures:      1,
+			err:           newConfigurationError("invalid"),
+			at:            time.Hour,
+			sourceVersion: "v1",
+		},
+		{
+			name:          "retried after terminal error when source changed",
+			failures:      1,
+			err:           newConfigurationError("invalid"),
+			at:            time.Hour,
+			sourceVersion: "v2",
+			wantReady:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var b targetBackoff
+			for i := 0; i < tc.failures; i++ {
+				b.failed(key, "v1", tc.err, now, time.Second, 10*time.Second, tc.maxRetries)
+			}
+			at := now.Add(tc.at)
+			if got := b.ready(key, tc.sourceVersion, at); got != tc.wantReady {
+				t.Errorf("ready: got %v, wanted %v", got, tc.wantReady)
+			}
+			if got := b.nextRetry(at); got != tc.wantNextRetry {
+				t.Errorf("nextRetry: got %v, wanted %v", got, tc.wantNextRetry)
+			}
+			b.succeeded(key)
+			if got := len(b.failing()); got != 0 {
+				t.Errorf("got %d failing targets after success, wanted 0", got)
+			}
+		})
+	}
+}
+
+func Test_targetBackoff_prune(t *testing.T) {
+	var b targetBackoff
+	now := time.Unix(0, 0)
+	seen := targetKey{source: "ns/secret1", namespace: "ns2"}
+	gone := targetKey{source: "ns/secret1", namespace: "ns3"}
+	b.failed(seen, "v1", errors.New("failed"), now, time.Second, time.Minute, 0)
+	b.failed(gone, "v1", errors.New("failed"), now, time.Second, time.Minute, 0)
+
+	pass := now.Add(time.Minute)
+	b.ready(seen, "v1", pass)
+	b.prune(pass)
+
+	failing := b.failing()
+	if _, ok := failing[seen]; !ok || len(failing) != 1 {
+		t.Errorf("got failing targets %v, wanted only %v", failing, seen)
+	}
+}
@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// certificate is the result of parsing the certificate and key of a TLS secret.
+type certificate struct {
+	// notAfter is the expiry of the leaf certificate
+	notAfter time.Time
+	// err explains why the certificate or key is invalid
+	err error
+}
+
+// parseCertificate parses the certificate chain and key of a TLS secret
+// and checks that the key matches the leaf certificate.
+func parseCertificate(secret *corev1.Secret) certificate {
+	pair, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return certificate{err: err}
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return certificate{err: err}
+	}
+	return certificate{notAfter: leaf.NotAfter}
+}
+
+// validate returns an error if the certificate is invalid or expired at now.
+func (c certificate) validate(now time.Time) error {
+	if c.err != nil {
+		return c.err
+	}
+	if !now.Before(c.notAfter) {
+		return fmt.Errorf("certificate expired at %s", c.notAfter.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// certificateCache caches parsed certificates by content, because the duplicates of a source secret
+// and the source secret share their content and are inspected in every reconcile.
+type certificateCache struct {
+	mu      sync.Mutex
+	entries map[string]certificate
+	// used contains the entries inspected since the last prune
+	used map[string]bool
+}
+
+// inspect returns the parsed certificate of secret, or false if secret isn't a TLS secret.
+func (c *certificateCache) inspect(secret *corev1.Secret) (certificate, bool) {
+	if secret.Type != corev1.SecretTypeTLS {
+		return certificate{}, false
+	}
+	key := contentHash(secret.Data, secret.Type)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]certificate)
+		c.used = make(map[string]bool)
+	}
+	cert, ok := c.entries[key]
+	if !ok {
+		cert = parseCertificate(secret)
+		c.entries[key] = cert
+	}
+	c.used[key] = true
+	return cert, true
+}
+
+// prune drops the entries that weren't inspected since the last prune.
+func (c *certificateCache) prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if !c.used[key] {
+			delete(c.entries, key)
+		}
+	}
+	c.used = make(map[string]bool)
+}
+
+// certificateExpiries contains the label values of the certificate expiry series reported by the last reconcile.
+type certificateExpiries struct {
+	sources    map[[3]string]bool
+	duplicates map[[3]string]bool
+}
+
+// observeCertificateExpiry reports the expiry of the certificates of TLS source secrets and their duplicates
+// in the local cluster. The series of deleted secrets and invalid certificates are deleted. The gauges aren't reset,
+// because scrapes between the reset and the next report would miss all series.
+func (r *SecretReconciler) observeCertificateExpiry(allSources, allDuplicates []*corev1.Secret) {
+	sources := make(map[[3]string]bool)
+	for _, source := range allSources {
+		if cert, ok := r.certificates.inspect(source); ok && cert.err == nil {
+			labels := [3]string{client.ObjectKeyFromObject(source).String(), source.Namespace, source.Name}
+			sourceCertificateNotAfterGauge.WithLabelValues(labels[:]...).Set(float64(cert.notAfter.Unix()))
+			sources[labels] = true
+		}
+	}
+	duplicates := make(map[[3]string]bool)
+	for _, duplicate := range allDuplicates {
+		if cert, ok := r.certificates.inspect(duplicate); ok && cert.err == nil {
+			labels := [3]string{duplicate.Annotations[duplicatorFromAnnotationKey], duplicate.Namespace, duplicate.Name}
+			duplicateCertificateNotAfterGauge.WithLabelValues(labels[:]...).Set(float64(cert.notAfter.Unix()))
+			duplicates[labels] = true
+		}
+	}
+	deleteStaleSeries(sourceCertificateNotAfterGauge, r.certificateExpiries.sources, sources)
+	deleteStaleSeries(duplicateCertificateNotAfterGauge, r.certificateExpiries.duplicates, duplicates)
+	r.certificateExpiries = certificateExpiries{sources: sources, duplicates: duplicates}
+}
+
+// deleteStaleSeries deletes the series of gauge with the label values in previous that aren't in current.
+func deleteStaleSeries(gauge *prometheus.GaugeVec, previous, current map[[3]string]bool) {
+	for labels := range previous {
+		if !current[labels] {
+			gauge.DeleteLabelValues(labels[:]...)
+		}
+	}
+}
+
+// validateCertificate returns an error if source is a TLS secret with an invalid or expired certificate.
+func (r *SecretReconciler) validateCertificate(source *corev1.Secret) error {
+	cert, ok := r.certificates.inspect(source)
+	if !ok {
+		return nil
+	}
+	return cert.validate(time.Now())
+}
+
+// isCertificateHeld returns true if the duplicates of source are held back at their current content,
+// because source has an invalid certificate and HoldInvalidCertificates is set.
+func (r *SecretReconciler) isCertificateHeld(source *corev1.Secret) bool {
+	return r.HoldInvalidCertificates && r.validateCertificate(source) != nil
+}
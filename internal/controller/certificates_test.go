@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newTestCertificate returns a PEM encoded self-signed certificate that expires at notAfter, and its PEM encoded key.
+func newTestCertificate(t *testing.T, notAfter time.Time) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "*.example.com"},
+		NotBefore:    notAfter.Add(-48 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func newTLSSecret(certPEM, keyPEM []byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "wildcard",
+			Namespace:   "cert-manager",
+			Annotations: map[string]string{duplicatorDuplicateAnnotationKey: "true"},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{corev1.TLSCertKey: certPEM, corev1.TLSPrivateKeyKey: keyPEM},
+	}
+}
+
+func Test_SecretReconciler_validateCertificate(t *testing.T) {
+	notAfter := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	validCert, validKey := newTestCertificate(t, notAfter)
+	expiredCert, expiredKey := newTestCertificate(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	_, otherKey := newTestCertificate(t, notAfter)
+
+	testCases := []struct {
+		name       string
+		secret     *corev1.Secret
+		wantErr    string
+		wantAnyErr bool
+	}{
+		{
+			name:   "valid",
+			secret: newTLSSecret(validCert, validKey),
+		},
+		{
+			name: "not a TLS secret",
+			secret: &corev1.Secret{
+				Type: corev1.SecretTypeOpaque,
+				Data: map[string][]byte{corev1.TLSCertKey: []byte("garbage")},
+			},
+		},
+		{
+			name:    "expired",
+			secret:  newTLSSecret(expiredCert, expiredKey),
+			wantErr: "certificate expired at 2020-01-01T00:00:00Z",
+		},
+		{
+			name:    "key doesn't match",
+			secret:  newTLSSecret(validCert, otherKey),
+			wantErr: "tls: private key does not match public key",
+		},
+		{
+			name:    "missing key",
+			secret:  newTLSSecret(validCert, nil),
+			wantErr: "tls: failed to find any PEM data in key input",
+		},
+		{
+			name:       "garbage certificate",
+			secret:     newTLSSecret([]byte("garbage"), validKey),
+			wantAnyErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &SecretReconciler{}
+			err := r.validateCertificate(tc.secret)
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if tc.wantAnyErr {
+				if err == nil {
+					t.Errorf("got no error, wanted an error")
+				}
+			} else if gotErr != tc.wantErr {
+				t.Errorf("got error %q, wanted %q", gotErr, tc.wantErr)
+			}
+
+			r.HoldInvalidCertificates = true
+			if got, want := r.isSourceFrozen(tc.secret), err != nil; got != want {
+				t.Errorf("got frozen %v with hold, wanted %v", got, want)
+			}
+			r.HoldInvalidCertificates = false
+			if r.isSourceFrozen(tc.secret) {
+				t.Errorf("got frozen without hold")
+			}
+		})
+	}
+}
+
+func Test_certificateCache(t *testing.T) {
+	notAfter := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	certPEM, keyPEM := newTestCertificate(t, notAfter)
+	secret := newTLSSecret(certPEM, keyPEM)
+	var cache certificateCache
+
+	cert, ok := cache.inspect(secret)
+	if !ok || cert.err != nil || !cert.notAfter.Equal(notAfter) {
+		t.Fatalf("got certificate %+v, %v, wanted notAfter %v", cert, ok, notAfter)
+	}
+	cache.prune()
+	if len(cache.entries) != 1 {
+		t.Errorf("got %d entries after prune, wanted the inspected entry", len(cache.entries))
+	}
+	cache.prune()
+	if len(cache.entries) != 0 {
+		t.Errorf("got %d entries after second prune, wanted none", len(cache.entries))
+	}
+}
+
+func Test_SecretReconciler_observeCertificateExpiry(t *testing.T) {
+	renewed := time.Now().Add(60 * 24 * time.Hour).Truncate(time.Second)
+	old := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	renewedCert, renewedKey := newTestCertificate(t, renewed)
+	oldCert, oldKey := newTestCertificate(t, old)
+	source := newTLSSecret(renewedCert, renewedKey)
+	updated := newDuplicateSecret(source, "ns1")
+	outdated := newDuplicateSecret(newTLSSecret(oldCert, oldKey), "ns2")
+	invalid := newDuplicateSecret(newTLSSecret([]byte("garbage"), nil), "ns3")
+	opaque := newDuplicateSecret(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "ns"}}, "ns1")
+
+	r := &SecretReconciler{}
+	r.observeCertificateExpiry([]*corev1.Secret{source}, []*corev1.Secret{updated, outdated, invalid, opaque})
+
+	if got := testutil.ToFloat64(sourceCertificateNotAfterGauge.WithLabelValues(
+		"cert-manager/wildcard", "cert-manager", "wildcard")); got != float64(renewed.Unix()) {
+		t.Errorf("got source expiry %v, wanted %v", got, renewed.Unix())
+	}
+	for _, tc := range []struct {
+		namespace string
+		want      time.Time
+	}{
+		{namespace: "ns1", want: renewed},
+		{namespace: "ns2", want: old},
+	} {
+		got := testutil.ToFloat64(duplicateCertificateNotAfterGauge.WithLabelValues(
+			"cert-manager/wildcard", tc.namespace, "wildcard"))
+		if got != float64(tc.want.Unix()) {
+			t.Errorf("%s: got duplicate expiry %v, wanted %v", tc.namespace, got, tc.want.Unix())
+		}
+	}
+	if got := testutil.CollectAndCount(duplicateCertificateNotAfterGauge); got != 2 {
+		t.Errorf("got %d duplicate expiries, wanted 2", got)
+	}
+
+	// The series of deleted duplicates are deleted, the other series are kept
+	r.observeCertificateExpiry([]*corev1.Secret{source}, []*corev1.Secret{updated})
+	if got := testutil.CollectAndCount(duplicateCertificateNotAfterGauge); got != 1 {
+		t.Errorf("got %d duplicate expiries after deleting a duplicate, wanted 1", got)
+	}
+	if got := testutil.CollectAndCount(sourceCertificateNotAfterGauge); got != 1 {
+		t.Errorf("got %d source expiries, wanted 1", got)
+	}
+	r.observeCertificateExpiry(nil, nil)
+	if got := testutil.CollectAndCount(duplicateCertificateNotAfterGauge) +
+		testutil.CollectAndCount(sourceCertificateNotAfterGauge); got != 0 {
+		t.Errorf("got %d expiries after deleting all secrets, wanted none", got)
+	}
+}
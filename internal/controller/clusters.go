@@ -0,0 +1,457 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// kubeconfigSecretKey is the key of the kubeconfig in the kubeconfig secret of a remote cluster
+const kubeconfigSecretKey = "kubeconfig"
+
+// remoteClusterSyncTimeout limits how long a reconcile waits for the cache of a newly connected remote cluster
+const remoteClusterSyncTimeout = 10 * time.Second
+
+// targetCluster is a cluster that duplicates are synced into.
+type targetCluster struct {
+	// name is the name of the kubeconfig secret of a remote cluster, empty for the local cluster
+	name string
+	// sourceCluster is the name of the kubeconfig secret of the remote cluster that hosts the source secrets
+	// synced into the local cluster, empty for source secrets in the local cluster
+	sourceCluster string
+	// rollouts contains the staged rollouts of the source secrets by source annotation of their duplicates,
+	// and waves the rollout wave of each namespace
+	rollouts map[string]*rolloutStatus
+	waves    map[string]int
+	client.Client
+}
+
+func (t targetCluster) isRemote() bool {
+	return t.name != ""
+}
+
+// isLocal returns true if both the source secrets and the duplicates are in the local cluster.
+func (t targetCluster) isLocal() bool {
+	return t.name == "" && t.sourceCluster == ""
+}
+
+// clusterRegistry manages the connections to remote clusters.
+// Each remote cluster has its own client and cache. Changes to secrets and namespaces
+// in a remote cluster are reported with onChange.
+type clusterRegistry struct {
+	scheme   *runtime.Scheme
+	onChange func()
+	// qps and burst limit the requests to each remote cluster
+	qps   float32
+	burst int
+
+	mu sync.Mutex
+	// ctx is set once the registry is started, the caches of remote clusters stop when it is done
+	ctx      context.Context
+	clusters map[string]*remoteCluster
+}
+
+type remoteCluster struct {
+	cluster.Cluster
+	kubeconfigHash [sha256.Size]byte
+	cancel         context.CancelFunc
+}
+
+// newClusterRegistry returns a clusterRegistry. The clients of remote clusters have the same rate limits as config.
+func newClusterRegistry(scheme *runtime.Scheme, config *rest.Config, onChange func()) *clusterRegistry {
+	return &clusterRegistry{
+		scheme:   scheme,
+		onChange: onChange,
+		qps:      config.QPS,
+		burst:    config.Burst,
+		clusters: make(map[string]*remoteCluster),
+	}
+}
+
+// Start implements manager.Runnable. It blocks until ctx is done.
+// The registry requires leader election, so only the leader connects to remote clusters.
+func (c *clusterRegistry) Start(ctx context.Context) error {
+	c.mu.Lock()
+	c.ctx = ctx
+	c.mu.Unlock()
+	<-ctx.Done()
+	return nil
+}
+
+// get returns the connection to the remote cluster name. The connection is established on first use
+// and reestablished if kubeconfig changed.
+// The registry isn't locked while the cache of a new connection syncs, so that the other clusters stay available.
+func (c *clusterRegistry) get(ctx context.Context, name string, kubeconfig []byte) (cluster.Cluster, error) {
+	if c == nil {
+		return nil, errors.New("remote clusters are not set up")
+	}
+	kubeconfigHash := sha256.Sum256(kubeconfig)
+	c.mu.Lock()
+	registryCtx := c.ctx
+	existing, ok := c.clusters[name]
+	if ok && existing.kubeconfigHash != kubeconfigHash {
+		log.FromContext(ctx).Info("kubeconfig of remote cluster changed, reconnecting", "cluster", name)
+		existing.cancel()
+		delete(c.clusters, name)
+	}
+	c.mu.Unlock()
+	if registryCtx == nil {
+		return nil, errors.New("remote clusters are not started yet")
+	}
+	if ok && existing.kubeconfigHash == kubeconfigHash {
+		return existing, nil
+	}
+
+	remote, cancel, err := c.connect(ctx, registryCtx, name, kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.clusters[name]; ok {
+		// A concurrent get connected first
+		cancel()
+		if existing.kubeconfigHash == kubeconfigHash {
+			return existing, nil
+		}
+		return nil, errors.New("kubeconfig changed while connecting")
+	}
+	c.clusters[name] = &remoteCluster{
+		Cluster:        remote,
+		kubeconfigHash: kubeconfigHash,
+		cancel:         cancel,
+	}
+	log.FromContext(ctx).Info("connected to remote cluster", "cluster", name)
+	return remote, nil
+}
+
+// connect starts a cache for the remote cluster with kubeconfig that stops when registryCtx is done or cancel is
+// called, and waits for the cache to sync.
+func (c *clusterRegistry) connect(ctx, registryCtx context.Context, name string,
+	kubeconfig []byte) (cluster.Cluster, context.CancelFunc, error) {
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, nil, newConfigurationError("invalid kubeconfig: %w", err)
+	}
+	restConfig.QPS = c.qps
+	restConfig.Burst = c.burst
+	remote, err := cluster.New(restConfig, func(o *cluster.Options) {
+		o.Scheme = c.scheme
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	// Informers must be registered before the cache is started
+	for _, obj := range []client.Object{&corev1.Secret{}, &corev1.Namespace{}} {
+		informer, err := remote.GetCache().GetInformer(ctx, obj)
+		if err != nil {
+			return nil, nil, err
+		}
+		_, err = informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+			AddFunc:    func(interface{}) { c.onChange() },
+			UpdateFunc: func(interface{}, interface{}) { c.onChange() },
+			DeleteFunc: func(interface{}) { c.onChange() },
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	clusterCtx, cancel := context.WithCancel(registryCtx)
+	go func() {
+		if err := remote.Start(clusterCtx); err != nil {
+			log.FromContext(ctx).Error(err, "remote cluster stopped", "cluster", name)
+		}
+	}()
+	syncCtx, syncCancel := context.WithTimeout(ctx, remoteClusterSyncTimeout)
+	defer syncCancel()
+	if !remote.GetCache().WaitForCacheSync(syncCtx) {
+		cancel()
+		return nil, nil, errors.New("timed out waiting for cache to sync")
+	}
+	return remote, cancel, nil
+}
+
+// remove closes the connection to the remote cluster name.
+func (c *clusterRegistry) remove(name string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.clusters[name]; ok {
+		existing.cancel()
+		delete(c.clusters, name)
+	}
+}
+
+// names returns the sorted names of the connected remote clusters.
+func (c *clusterRegistry) names() []string {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, 0, len(c.clusters))
+	for name := range c.clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reconcileRemoteClusters syncs duplicates into the remote clusters that source secrets reference
+// with the clusters annotation. A remote cluster that isn't referenced anymore gets passes
+// that delete its duplicates until a pass doesn't find any, then the connection is closed.
+// The errors are returned by remote cluster name.
+func (r *SecretReconciler) reconcileRemoteClusters(ctx context.Context, allSecrets *corev1.SecretList,
+	allSources []*corev1.Secret) map[string]error {
+	referencedClusters := make(map[string]bool)
+	for _, source := range allSources {
+		for _, name := range splitList(source.Annotations[duplicatorClustersAnnotationKey]) {
+			referencedClusters[name] = true
+		}
+	}
+	var clusterNames []string
+	for _, name := range r.clusters.names() {
+		// Source clusters stay connected even if no duplicates are synced into them
+		if referencedClusters[name] || !slices.Contains(r.SourceClusters, name) {
+			clusterNames = append(clusterNames, name)
+		}
+	}
+	for name := range referencedClusters {
+		if !slices.Contains(clusterNames, name) {
+			clusterNames = append(clusterNames, name)
+		}
+	}
+	sort.Strings(clusterNames)
+
+	clusterErrors := make(map[string]error)
+	for _, name := range clusterNames {
+		kubeconfigSecret := findKubeconfigSecret(allSecrets, r.ControllerNamespace, name)
+		if !referencedClusters[name] && kubeconfigSecret == nil {
+			// Duplicates in a remote cluster can't be deleted anymore once its kubeconfig secret is gone
+			r.clusters.remove(name)
+			remoteClusterUpGauge.DeleteLabelValues(name)
+			continue
+		}
+		duplicates, err := r.reconcileRemoteCluster(ctx, name, kubeconfigSecret, allSources)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "failed to reconcile remote cluster", "cluster", name)
+			remoteClusterUpGauge.WithLabelValues(name).Set(0)
+			clusterErrors[name] = err
+			continue
+		}
+		// Failed deletes are retried with backoff without failing the pass,
+		// so only a pass that finds no duplicates proves that all of them are gone
+		if referencedClusters[name] || duplicates > 0 {
+			remoteClusterUpGauge.WithLabelValues(name).Set(1)
+		} else {
+			r.clusters.remove(name)
+			remoteClusterUpGauge.DeleteLabelValues(name)
+		}
+	}
+	return clusterErrors
+}
+
+// reconcileRemoteCluster syncs the duplicates in the remote cluster name.
+// It returns the number of owned duplicates that the remote cluster had before the pass.
+func (r *SecretReconciler) reconcileRemoteCluster(ctx context.Context, name string, kubeconfigSecret *corev1.Secret,
+	allSources []*corev1.Secret) (int, error) {
+	if r.ClusterName == "" {
+		return 0, newConfigurationError("the cluster name must be set to sync duplicates into remote clusters")
+	}
+	remote, err := r.connectRemoteCluster(ctx, name, kubeconfigSecret)
+	if err != nil {
+		return 0, err
+	}
+	target := targetCluster{name: name, Client: remote.GetClient()}
+
+	allSecrets := &corev1.SecretList{}
+	err = target.List(ctx, allSecrets)
+	if err != nil {
+		return 0, err
+	}
+	allNamespaces := &corev1.NamespaceList{}
+	err = target.List(ctx, allNamespaces)
+	if err != nil {
+		return 0, err
+	}
+	allDuplicates := findAllRemoteDuplicateSecrets(allSecrets, r.ClusterName)
+	allDuplicates = r.ownedDuplicates(ctx, r.ownership.Load(), allDuplicates)
+	nonTerminatingNamespaces := findNonTerminatingNamespaces(allNamespaces.Items)
+	pausedNamespaces := findPausedNamespaces(allNamespaces.Items)
+	return len(allDuplicates), r.reconcileCluster(ctx, target, allSecrets, nonTerminatingNamespaces, pausedNamespaces,
+		allDuplicates, allSources)
+}
+
+// reconcileSourceClusters syncs the source secrets of the remote source clusters into the local cluster.
+// The duplicates of source clusters that were removed from SourceClusters are deleted.
+// The errors are returned by remote cluster name.
+func (r *SecretReconciler) reconcileSourceClusters(ctx context.Context, allSecrets *corev1.SecretList,
+	allNamespaces []*corev1.Namespace, pausedNamespaces map[string]bool) map[string]error {
+	clusterErrors := make(map[string]error)
+	for _, name := range r.SourceClusters {
+		err := r.reconcileSourceCluster(ctx, name, allSecrets, allNamespaces, pausedNamespaces)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "failed to reconcile source cluster", "cluster", name)
+			remoteClusterUpGauge.WithLabelValues(name).Set(0)
+			clusterErrors[name] = err
+			continue
+		}
+		remoteClusterUpGauge.WithLabelValues(name).Set(1)
+	}
+
+	for _, name := range r.removedSourceClusters(allSecrets) {
+		log.FromContext(ctx).Info("deleting duplicates of removed source cluster", "cluster", name)
+		// Without source secrets, all duplicates of the source cluster are orphaned
+		target := targetCluster{sourceCluster: name, Client: r.Client}
+		allDuplicates := findAllRemoteDuplicateSecrets(allSecrets, name)
+		err := r.reconcileCluster(ctx, target, allSecrets, allNamespaces, pausedNamespaces, allDuplicates, nil)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "failed to delete duplicates of removed source cluster", "cluster", name)
+			clusterErrors[name] = err
+		}
+		remoteClusterUpGauge.DeleteLabelValues(name)
+	}
+	return clusterErrors
+}
+
+// removedSourceClusters returns the sorted names of the source clusters that aren't listed in SourceClusters anymore,
+// but still have owned duplicates in the local cluster.
+func (r *SecretReconciler) removedSourceClusters(allSecrets *corev1.SecretList) []string {
+	ownership := r.ownership.Load()
+	if ownership == nil {
+		return nil
+	}
+	removed := make(map[string]bool)
+	for i := range allSecrets.Items {
+		secret := &allSecrets.Items[i]
+		// Duplicates pushed into the local cluster by other clusters have the same prefix, but aren't owned
+		cluster, ok := remoteSourceCluster(secret)
+		if ok && !slices.Contains(r.SourceClusters, cluster) && ownership.owns(secret) {
+			removed[cluster] = true
+		}
+	}
+	names := make([]string, 0, len(removed))
+	for name := range removed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reconcileSourceCluster syncs the source secrets of the remote cluster name into the local cluster.
+// Duplicates are left untouched while the remote cluster is unavailable.
+func (r *SecretReconciler) reconcileSourceCluster(ctx context.Context, name string, allSecrets *corev1.SecretList,
+	allNamespaces []*corev1.Namespace, pausedNamespaces map[string]bool) error {
+	remote, err := r.connectRemoteCluster(ctx, name, findKubeconfigSecret(allSecrets, r.ControllerNamespace, name))
+	if err != nil {
+		return err
+	}
+
+	remoteSecrets := &corev1.SecretList{}
+	err = remote.GetClient().List(ctx, remoteSecrets)
+	if err != nil {
+		return err
+	}
+	remoteNamespaces := &corev1.NamespaceList{}
+	err = remote.GetClient().List(ctx, remoteNamespaces)
+	if err != nil {
+		return err
+	}
+	allSources := findAllSourceSecrets(remoteSecrets)
+	allSources, rejectedSources := r.partitionSourcesByNamespace(allSources, remoteNamespaces.Items)
+	log.FromContext(ctx).V(2).Info("found rejected source secrets in source cluster",
+		"cluster", name, "count", len(rejectedSources))
+
+	target := targetCluster{sourceCluster: name, Client: r.Client}
+	allDuplicates := findAllRemoteDuplicateSecrets(allSecrets, name)
+	allDuplicates = r.ownedDuplicates(ctx, r.ownership.Load(), allDuplicates)
+	return r.reconcileCluster(ctx, target, allSecrets, allNamespaces, pausedNamespaces, allDuplicates, allSources)
+}
+
+// connectRemoteCluster returns the connection to the remote cluster name.
+func (r *SecretReconciler) connectRemoteCluster(ctx context.Context, name string,
+	kubeconfigSecret *corev1.Secret) (cluster.Cluster, error) {
+	if kubeconfigSecret == nil {
+		return nil, newConfigurationError("kubeconfig secret %s/%s with label %s=true not found",
+			r.ControllerNamespace, name, duplicatorKubeconfigLabelKey)
+	}
+	return r.clusters.get(ctx, name, kubeconfigSecret.Data[kubeconfigSecretKey])
+}
+
+// enqueueFullReconcile triggers a full reconcile without blocking.
+// Triggers are coalesced while a full reconcile is already pending.
+func (r *SecretReconciler) enqueueFullReconcile() {
+	select {
+	case r.fullReconcileEvents <- event.GenericEvent{Object: &corev1.Secret{}}:
+	default:
+	}
+}
+
+// findControllerSecret returns the secret name in the namespace of the controller, or nil if it doesn't exist.
+func findControllerSecret(allSecrets *corev1.SecretList, namespace, name string) *corev1.Secret {
+	if namespace == "" {
+		return nil
+	}
+	for i := range allSecrets.Items {
+		secret := &allSecrets.Items[i]
+		if secret.Namespace == namespace && secret.Name == name {
+			return secret
+		}
+	}
+	return nil
+}
+
+// findKubeconfigSecret returns the kubeconfig secret name in the namespace of the controller,
+// or nil if it doesn't exist or doesn't have the kubeconfig label.
+// The label keeps other secrets in the namespace of the controller, e.g. the ownership key secret,
+// from being used as kubeconfig.
+func findKubeconfigSecret(allSecrets *corev1.SecretList, namespace, name string) *corev1.Secret {
+	secret := findControllerSecret(allSecrets, namespace, name)
+	if secret == nil || secret.Labels[duplicatorKubeconfigLabelKey] != "true" {
+		return nil
+	}
+	return secret
+}
+
+// findAllRemoteDuplicateSecrets finds the duplicates with a source annotation prefixed with clusterName.
+// These are the duplicates pushed by the cluster clusterName into a remote cluster,
+// or the duplicates of source secrets in the remote source cluster clusterName.
+func findAllRemoteDuplicateSecrets(allSecrets *corev1.SecretList, clusterName string) []*corev1.Secret {
+	duplicated := make([]*corev1.Secret, 0)
+	for _, s := range allSecrets.Items {
+		secret := s
+		if cluster, ok := remoteSourceCluster(&secret); ok && cluster == clusterName {
+			duplicated = append(duplicated, &secret)
+		}
+	}
+	return duplicated
+}
+
+// remoteSourceCluster returns the cluster name of the source annotation of secret if it references a source secret
+// in another cluster with cluster/namespace/name, or false otherwise.
+func remoteSourceCluster(secret *corev1.Secret) (string, bool) {
+	parts := strings.Split(secret.Annotations[duplicatorFromAnnotationKey], "/")
+	if len(parts) != 3 {
+		return "", false
+	}
+	return parts[0], true
+}
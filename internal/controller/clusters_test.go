@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+)
+
+func Test_SecretReconciler_reconcileSourceClusters_removed(t *testing.T) {
+	key := &ownershipKey{owner: "uid-1", key: []byte("0123456789abcdef0123456789abcdef")}
+	newDuplicate := func(from string, owned bool) *corev1.Secret {
+		duplicate := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "secret1",
+				Namespace:   "ns2",
+				Annotations: map[string]string{duplicatorFromAnnotationKey: from},
+			},
+		}
+		if owned {
+			key.sign(duplicate)
+		} else {
+			(&ownershipKey{owner: "uid-2", key: key.key}).sign(duplicate)
+		}
+		return duplicate
+	}
+	removed := newDuplicate("central/ns1/secret1", true)
+	pushed := newDuplicate("other/ns1/secret1", false)
+	pushed.Namespace = "ns3"
+	c := fake.NewClientBuilder().WithObjects(removed, pushed).Build()
+	r := &SecretReconciler{Client: c, Recorder: record.NewFakeRecorder(10)}
+	r.ownership.Store(key)
+	allSecrets := &corev1.SecretList{}
+	if err := c.List(context.Background(), allSecrets); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	namespaces := []*corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "ns2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "ns3"}},
+	}
+
+	if errs := r.reconcileSourceClusters(context.Background(), allSecrets, namespaces, nil); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	err := c.Get(context.Background(), client.ObjectKeyFromObject(removed), &corev1.Secret{})
+	if !k8sErrors.IsNotFound(err) {
+		t.Errorf("expected duplicate of removed source cluster to be deleted, got err %v", err)
+	}
+	err = c.Get(context.Background(), client.ObjectKeyFromObject(pushed), &corev1.Secret{})
+	if err != nil {
+		t.Errorf("expected duplicate pushed by another cluster to be kept, got err %v", err)
+	}
+}
+
+// fakeCluster is a remote cluster that only provides a client.
+type fakeCluster struct {
+	cluster.Cluster
+	client client.Client
+}
+
+func (f fakeCluster) GetClient() client.Client {
+	return f.client
+}
+
+func Test_SecretReconciler_reconcileRemoteClusters_removed(t *testing.T) {
+	key := &ownershipKey{owner: "uid-1", key: []byte("0123456789abcdef0123456789abcdef")}
+	duplicate := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "secret1",
+			Namespace:   "ns2",
+			Annotations: map[string]string{duplicatorFromAnnotationKey: "local/ns1/secret1"},
+		},
+	}
+	key.sign(duplicate)
+	failDelete := true
+	remote := fake.NewClientBuilder().
+		WithObjects(duplicate, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns2"}}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+				if failDelete {
+					return errors.New("unavailable")
+				}
+				return c.Delete(ctx, obj, opts...)
+			},
+		}).
+		Build()
+	kubeconfig := []byte("kubeconfig")
+	allSecrets := &corev1.SecretList{Items: []corev1.Secret{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "edge-1",
+			Namespace: "duplicator",
+			Labels:    map[string]string{duplicatorKubeconfigLabelKey: "true"},
+		},
+		Data: map[string][]byte{kubeconfigSecretKey: kubeconfig},
+	}}}
+	r := &SecretReconciler{
+		Recorder:            record.NewFakeRecorder(10),
+		ClusterName:         "local",
+		ControllerNamespace: "duplicator",
+		TargetBackoffBase:   time.Nanosecond,
+		clusters: &clusterRegistry{
+			ctx: context.Background(),
+			clusters: map[string]*remoteCluster{"edge-1": {
+				Cluster:        fakeCluster{client: remote},
+				kubeconfigHash: sha256.Sum256(kubeconfig),
+				cancel:         func() {},
+			}},
+		},
+	}
+	r.ownership.Store(key)
+
+	steps := []struct {
+		name          string
+		failDelete    bool
+		wantConnected bool
+	}{
+		{name: "failed delete keeps the connection", failDelete: true, wantConnected: true},
+		{name: "delete keeps the connection until it is confirmed", wantConnected: true},
+		{name: "no duplicates left closes the connection", wantConnected: false},
+	}
+	for _, step := range steps {
+		failDelete = step.failDelete
+		time.Sleep(time.Millisecond)
+		if errs := r.reconcileRemoteClusters(context.Background(), allSecrets, nil); len(errs) != 0 {
+			t.Fatalf("%s: unexpected errors: %v", step.name, errs)
+		}
+		if got := len(r.clusters.names()) == 1; got != step.wantConnected {
+			t.Errorf("%s: got connected %v, wanted %v", step.name, got, step.wantConnected)
+		}
+	}
+	err := remote.Get(context.Background(), client.ObjectKeyFromObject(duplicate), &corev1.Secret{})
+	if !k8sErrors.IsNotFound(err) {
+		t.Errorf("expected duplicate in removed remote cluster to be deleted, got err %v", err)
+	}
+}
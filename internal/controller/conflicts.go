@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// conflictTracker tracks the duplicates that can't be created because another secret with their name exists,
+// e.g. a duplicate of a source secret with the same name in a remote source cluster,
+// or a duplicate without valid ownership marker. The zero value is ready to use.
+type conflictTracker struct {
+	mu        sync.Mutex
+	conflicts map[targetKey]string
+	// seen contains the conflicts found since the last prune
+	seen map[targetKey]bool
+}
+
+// add records that the target is blocked for the reason in message.
+// It returns true if the conflict is new, so that it is reported once instead of with every reconcile.
+func (c *conflictTracker) add(key targetKey, message string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conflicts == nil {
+		c.conflicts = make(map[targetKey]string)
+		c.seen = make(map[targetKey]bool)
+	}
+	c.seen[key] = true
+	if c.conflicts[key] == message {
+		return false
+	}
+	c.conflicts[key] = message
+	return true
+}
+
+// prune forgets the conflicts that weren't found since the last prune, e.g. because the blocking secret was deleted.
+func (c *conflictTracker) prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.conflicts {
+		if !c.seen[key] {
+			delete(c.conflicts, key)
+		}
+	}
+	c.seen = make(map[targetKey]bool)
+	duplicateConflictsGauge.Set(float64(len(c.conflicts)))
+}
+
+// forSource returns the conflicts of the duplicates of the local source secret sourceKey,
+// or of its versioned copies if versions is set, by status key.
+func (c *conflictTracker) forSource(sourceKey string, versions bool) map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var targets map[string]string
+	for key, message := range c.conflicts {
+		if key.source != sourceKey || (key.version != "") != versions {
+			continue
+		}
+		if targets == nil {
+			targets = make(map[string]string)
+		}
+		targets[key.statusKey()] = message
+	}
+	return targets
+}
+
+// conflictMessage describes the existing secret that blocks the duplicate with the source annotation reference.
+func conflictMessage(existing *corev1.Secret, reference string) string {
+	key := client.ObjectKeyFromObject(existing)
+	from, ok := existing.Annotations[duplicatorFromAnnotationKey]
+	switch {
+	case ok && from == reference:
+		return fmt.Sprintf("secret %s is a duplicate without valid ownership marker", key)
+	case ok:
+		return fmt.Sprintf("secret %s is a duplicate of %s", key, from)
+	default:
+		return fmt.Sprintf("secret %s exists and isn't a duplicate", key)
+	}
+}
@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_SecretReconciler_reconcileSources_conflicts(t *testing.T) {
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "secret1",
+			Namespace:   "ns1",
+			Annotations: map[string]string{duplicatorDuplicateAnnotationKey: "true"},
+		},
+	}
+	remoteDuplicate := newDuplicateSecret(source, "ns2")
+	remoteDuplicate.Annotations[duplicatorFromAnnotationKey] = "central/ns1/secret1"
+	unrelated := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "secret1", Namespace: "ns3"}}
+	ownership := &ownershipKey{owner: "uid-1", key: []byte("0123456789abcdef0123456789abcdef")}
+	duplicate := newDuplicateSecret(source, "ns4")
+	ownership.sign(duplicate)
+	unowned := newDuplicateSecret(source, "ns5")
+	unowned.Labels = map[string]string{duplicatorOwnerLabelKey: "uid-2"}
+	existingSecrets := map[client.ObjectKey]*corev1.Secret{}
+	for _, secret := range []*corev1.Secret{source, remoteDuplicate, unrelated, duplicate, unowned} {
+		existingSecrets[client.ObjectKeyFromObject(secret)] = secret
+	}
+	namespaces := []*corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "ns2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "ns3"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "ns4"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "ns5"}},
+	}
+	recorder := record.NewFakeRecorder(10)
+	r := &SecretReconciler{Client: fake.NewClientBuilder().Build(), Recorder: recorder}
+	r.ownership.Store(ownership)
+
+	for i, wantEvents := range []int{3, 0} {
+		err := r.reconcileSources(context.Background(), targetCluster{Client: r.Client}, existingSecrets, namespaces,
+			nil, []*corev1.Secret{source})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		r.conflicts.prune()
+		if got := recordedEvents(recorder); len(got) != wantEvents {
+			t.Errorf("reconcile %d: got events %v, wanted %d", i, got, wantEvents)
+		}
+	}
+	want := map[string]string{
+		"ns2": "secret ns2/secret1 is a duplicate of central/ns1/secret1",
+		"ns3": "secret ns3/secret1 exists and isn't a duplicate",
+		"ns5": "secret ns5/secret1 is a duplicate without valid ownership marker",
+	}
+	if got := r.conflicts.forSource("ns1/secret1", false); !reflect.DeepEqual(got, want) {
+		t.Errorf("got conflicts %v, wanted %v", got, want)
+	}
+
+	// Conflicts that aren't found anymore are forgotten
+	for _, namespace := range []string{"ns2", "ns3", "ns5"} {
+		resolved := newDuplicateSecret(source, namespace)
+		ownership.sign(resolved)
+		existingSecrets[client.ObjectKeyFromObject(resolved)] = resolved
+	}
+	err := r.reconcileSources(context.Background(), targetCluster{Client: r.Client}, existingSecrets, namespaces,
+		nil, []*corev1.Secret{source})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r.conflicts.prune()
+	if got := r.conflicts.forSource("ns1/secret1", false); got != nil {
+		t.Errorf("got conflicts %v after they were resolved, wanted none", got)
+	}
+}
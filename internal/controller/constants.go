@@ -1,4 +1,79 @@
 package controller
 
+const duplicatorAnnotationPrefix = "duplicator.k8s.nicktriller.com/"
+
 const duplicatorDuplicateAnnotationKey = "duplicator.k8s.nicktriller.com/duplicate"
 const duplicatorFromAnnotationKey = "duplicator.k8s.nicktriller.com/source"
+const duplicatorPausedAnnotationKey = "duplicator.k8s.nicktriller.com/paused"
+const duplicatorStatusAnnotationKey = "duplicator.k8s.nicktriller.com/status"
+const duplicatorPullAllowedAnnotationKey = "duplicator.k8s.nicktriller.com/pull-allowed"
+const duplicatorPullAllowedNamespacesAnnotationKey = "duplicator.k8s.nicktriller.com/pull-allowed-namespaces"
+const duplicatorClustersAnnotationKey = "duplicator.k8s.nicktriller.com/clusters"
+const duplicatorSignatureAnnotationKey = "duplicator.k8s.nicktriller.com/signature"
+const duplicatorSourceUIDAnnotationKey = "duplicator.k8s.nicktriller.com/source-uid"
+const duplicatorSourceResourceVersionAnnotationKey = "duplicator.k8s.nicktriller.com/source-resource-version"
+const duplicatorContentHashAnnotationKey = "duplicator.k8s.nicktriller.com/content-hash"
+const duplicatorRestartWorkloadsAnnotationKey = "duplicator.k8s.nicktriller.com/restart-workloads"
+const duplicatorStagedRolloutAnnotationKey = "duplicator.k8s.nicktriller.com/staged-rollout"
+const duplicatorPinRevisionAnnotationKey = "duplicator.k8s.nicktriller.com/pin-revision"
+const duplicatorImmutableAnnotationKey = "duplicator.k8s.nicktriller.com/immutable"
+const duplicatorVersionedNamesAnnotationKey = "duplicator.k8s.nicktriller.com/versioned-names"
+const duplicatorSupersededAtAnnotationKey = "duplicator.k8s.nicktriller.com/superseded-at"
+
+// duplicatorVersionLabelKey is used on versioned copies to record their abbreviated revision,
+// and duplicatorCurrentLabelKey to select the versioned copy of the current revision
+const duplicatorVersionLabelKey = "duplicator.k8s.nicktriller.com/version"
+const duplicatorCurrentLabelKey = "duplicator.k8s.nicktriller.com/current"
+
+// duplicatorAcceptRecreatedFromAnnotationKey is used on recreated source secrets with the UID of the previous
+// source secret to update the duplicates despite the reject policy
+const duplicatorAcceptRecreatedFromAnnotationKey = "duplicator.k8s.nicktriller.com/accept-recreated-from"
+
+// duplicatorHistoryOfAnnotationKey is used on history secrets to identify their source secret
+const duplicatorHistoryOfAnnotationKey = "duplicator.k8s.nicktriller.com/history-of"
+
+// duplicatorRolloutWaveLabelKey is used on namespaces to assign them to a wave of staged rollouts
+const duplicatorRolloutWaveLabelKey = "duplicator.k8s.nicktriller.com/rollout-wave"
+
+// duplicatorRestartPendingAnnotationKey is used on duplicates with the content hash they were updated to
+// until the workloads consuming them were restarted
+const duplicatorRestartPendingAnnotationKey = "duplicator.k8s.nicktriller.com/restart-pending"
+
+// duplicatorRestartedAtAnnotationKey is used on pod templates of workloads that were restarted
+const duplicatorRestartedAtAnnotationKey = "duplicator.k8s.nicktriller.com/restarted-at"
+
+// duplicatorKubeconfigLabelKey is used on secrets in the controller namespace that contain the kubeconfig
+// of a remote cluster
+const duplicatorKubeconfigLabelKey = "duplicator.k8s.nicktriller.com/kubeconfig"
+
+// duplicatorOwnerLabelKey is used on duplicates to identify the controller that owns them
+const duplicatorOwnerLabelKey = "duplicator.k8s.nicktriller.com/owner"
+
+// duplicatorPullAnnotationKey is used on namespaces to request source secrets in pull mode
+const duplicatorPullAnnotationKey = "duplicator.k8s.nicktriller.com/pull"
+
+// duplicatorIgnoreKey is used as annotation or label key on namespaces
+const duplicatorIgnoreKey = "duplicator.k8s.nicktriller.com/ignore"
+
+const (
+	operationCreate = "create"
+	operationUpdate = "update"
+	operationDelete = "delete"
+)
+
+// Event reasons
+const (
+	reasonDryRunCreate = "DryRunCreate"
+	reasonDryRunUpdate = "DryRunUpdate"
+	reasonDryRunDelete = "DryRunDelete"
+
+	reasonSourceRejected     = "SourceRejected"
+	reasonInvalidAnnotations = "InvalidAnnotations"
+	reasonUnknownAnnotations = "UnknownAnnotations"
+	reasonSourceRecreated    = "SourceRecreated"
+	reasonWorkloadRestarted  = "WorkloadRestarted"
+	reasonRolloutWaveStarted = "RolloutWaveStarted"
+	reasonRevisionNotFound   = "RevisionNotFound"
+	reasonInvalidCertificate = "InvalidCertificate"
+	reasonDuplicateConflict  = "DuplicateConflict"
+)
@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// configurationError is caused by the configuration of the controller or of a source secret,
+// e.g. a missing kubeconfig secret. Retrying doesn't help until the configuration changes.
+type configurationError struct {
+	err error
+}
+
+func newConfigurationError(format string, args ...any) error {
+	return &configurationError{err: fmt.Errorf(format, args...)}
+}
+
+func (e *configurationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *configurationError) Unwrap() error {
+	return e.err
+}
+
+// isTerminalError returns true if retrying err with backoff can't succeed,
+// because err is a configuration error or the API server rejected the request as invalid.
+func isTerminalError(err error) bool {
+	var configErr *configurationError
+	return errors.As(err, &configErr) ||
+		k8sErrors.IsInvalid(err) ||
+		k8sErrors.IsBadRequest(err) ||
+		k8sErrors.IsRequestEntityTooLargeError(err)
+}
+
+// flattenErrors returns the errors joined in err with errors.Join, or err itself if it isn't joined.
+func flattenErrors(err error) []error {
+	if err == nil {
+		return nil
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var errs []error
+	for _, e := range joined.Unwrap() {
+		errs = append(errs, flattenErrors(e)...)
+	}
+	return errs
+}
+
+// clusterErrorList returns the errors in clusterErrors sorted by cluster name and prefixed with the cluster name.
+func clusterErrorList(clusterErrors map[string]error) []error {
+	names := make([]string, 0, len(clusterErrors))
+	for name := range clusterErrors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		for _, err := range flattenErrors(clusterErrors[name]) {
+			errs = append(errs, fmt.Errorf("cluster %s: %w", name, err))
+		}
+	}
+	return errs
+}
+
+// aggregateErrors joins errs into the error of a reconcile.
+// If all errs are terminal, the result is a terminal error and the reconcile isn't retried with backoff.
+// Otherwise, the reconcile is retried, including its terminal errors.
+func aggregateErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	err := errors.Join(errs...)
+	for _, e := range errs {
+		if !isTerminalError(e) {
+			return err
+		}
+	}
+	return reconcile.TerminalError(err)
+}
@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func Test_aggregateErrors(t *testing.T) {
+	secretResource := schema.GroupResource{Resource: "secrets"}
+	retryable := fmt.Errorf("update duplicate ns/secret1 of source default/secret1: %w",
+		k8sErrors.NewConflict(secretResource, "secret1", errors.New("modified")))
+	invalid := fmt.Errorf("create duplicate ns/secret2 of source default/secret2: %w",
+		k8sErrors.NewInvalid(schema.GroupKind{Kind: "Secret"}, "secret2", nil))
+	configuration := fmt.Errorf("cluster edge: %w", newConfigurationError("kubeconfig secret %s not found", "edge"))
+
+	testCases := []struct {
+		name         string
+		errs         []error
+		wantErr      bool
+		wantTerminal bool
+	}{
+		{
+			name: "no errors",
+		},
+		{
+			name:    "retryable error",
+			errs:    []error{retryable},
+			wantErr: true,
+		},
+		{
+			name:         "terminal errors",
+			errs:         []error{invalid, configuration},
+			wantErr:      true,
+			wantTerminal: true,
+		},
+		{
+			name:    "terminal and retryable errors",
+			errs:    []error{invalid, retryable, configuration},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := aggregateErrors(tc.errs)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, wanted error %v", err, tc.wantErr)
+			}
+			if terminal := errors.Is(err, reconcile.TerminalError(nil)); terminal != tc.wantTerminal {
+				t.Errorf("got terminal %v, wanted terminal %v", terminal, tc.wantTerminal)
+			}
+			for _, e := range tc.errs {
+				if !errors.Is(err, e) {
+					t.Errorf("expected %v to contain %v", err, e)
+				}
+			}
+		})
+	}
+}
+
+func Test_clusterErrorList(t *testing.T) {
+	clusterErrors := map[string]error{
+		"b": errors.Join(errors.New("b1"), errors.New("b2")),
+		"a": errors.New("a1"),
+	}
+	var got []string
+	for _, err := range clusterErrorList(clusterErrors) {
+		got = append(got, err.Error())
+	}
+	want := []string{"cluster a: a1", "cluster b: b1", "cluster b: b2"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+}
+
+func Test_SecretReconciler_retryAfter(t *testing.T) {
+	retryable := aggregateErrors([]error{errors.New("transient")})
+	terminal := aggregateErrors([]error{newConfigurationError("invalid")})
+	r := &SecretReconciler{TargetBackoffBase: time.Second, TargetBackoffMax: 3 * time.Second}
+
+	steps := []struct {
+		err  error
+		want time.Duration
+	}{
+		{err: retryable, want: time.Second},
+		{err: retryable, want: 2 * time.Second},
+		{err: terminal, want: 0},
+		{err: retryable, want: 3 * time.Second},
+		{err: nil, want: 0},
+		{err: retryable, want: time.Second},
+	}
+	for i, step := range steps {
+		if got := r.retryAfter(step.err); got != step.want {
+			t.Errorf("step %d: got %v, wanted %v", i, got, step.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+// cacheSyncCheckTimeout limits how long a readiness probe waits for the informer caches
+const cacheSyncCheckTimeout = time.Second
+
+// CacheSyncChecker returns a readiness check that fails until the informer caches of c are synced.
+func CacheSyncChecker(c cache.Cache) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), cacheSyncCheckTimeout)
+		defer cancel()
+		if !c.WaitForCacheSync(ctx) {
+			return errors.New("informer caches are not synced")
+		}
+		return nil
+	}
+}
+
+// LivenessChecker is a liveness check that fails if a running reconcile didn't complete a write
+// for longer than StuckReconcileTimeout, or if the leader hasn't completed a reconcile within LivenessResyncPeriods
+// resync periods. The first reconcile of a large cluster can take longer than StuckReconcileTimeout,
+// because its writes are rate limited, so the progress of the reconcile is checked instead of its duration.
+// Replicas that aren't the leader don't reconcile and are always live.
+func (r *SecretReconciler) LivenessChecker(_ *http.Request) error {
+	if started := r.reconcileStartedAt.Load(); started != 0 && r.StuckReconcileTimeout > 0 {
+		idle := time.Since(time.Unix(0, max(started, r.lastProgressAt.Load())))
+		if idle > r.StuckReconcileTimeout {
+			return fmt.Errorf("reconcile made no progress for %s and looks stuck", idle.Round(time.Second))
+		}
+	}
+	leaderSince := r.leaderSince.Load()
+	if r.ResyncPeriod <= 0 || r.LivenessResyncPeriods <= 0 || leaderSince == 0 {
+		return nil
+	}
+	last := max(r.lastReconcileCompletedAt.Load(), leaderSince)
+	age := time.Since(time.Unix(0, last))
+	if age > time.Duration(r.LivenessResyncPeriods)*r.ResyncPeriod {
+		return fmt.Errorf("no reconcile completed for %s", age.Round(time.Second))
+	}
+	return nil
+}
+
+// reconcileStarted records the start of a reconcile for LivenessChecker.
+// Reconciles never run concurrently, so there is at most one running reconcile.
+func (r *SecretReconciler) reconcileStarted() {
+	r.reconcileStartedAt.Store(time.Now().UnixNano())
+}
+
+// observeProgress records for LivenessChecker that the running reconcile completed a write.
+func (r *SecretReconciler) observeProgress() {
+	r.lastProgressAt.Store(time.Now().UnixNano())
+}
+
+// reconcileCompleted records the completion of the running reconcile for LivenessChecker.
+func (r *SecretReconciler) reconcileCompleted() {
+	r.reconcileStartedAt.Store(0)
+	r.lastReconcileCompletedAt.Store(time.Now().UnixNano())
+}
@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+)
+
+func Test_CacheSyncChecker(t *testing.T) {
+	for _, synced := range []bool{true, false} {
+		c := &informertest.FakeInformers{Synced: &synced}
+		err := CacheSyncChecker(c)(httptest.NewRequest("GET", "/readyz", nil))
+		if (err == nil) != synced {
+			t.Errorf("synced %v: got error %v", synced, err)
+		}
+	}
+}
+
+func Test_SecretReconciler_LivenessChecker(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		name                  string
+		resyncPeriod          time.Duration
+		stuckReconcileTimeout time.Duration
+		leaderSince           time.Time
+		reconcileStartedAt    time.Time
+		lastProgressAt        time.Time
+		lastReconcileAt       time.Time
+		wantErr               bool
+	}{
+		{
+			name: "not leader",
+		},
+		{
+			name:                  "running reconcile",
+			stuckReconcileTimeout: time.Minute,
+			reconcileStartedAt:    now.Add(-time.Second),
+		},
+		{
+			name:                  "stuck reconcile",
+			stuckReconcileTimeout: time.Minute,
+			reconcileStartedAt:    now.Add(-2 * time.Minute),
+			wantErr:               true,
+		},
+		{
+			name:                  "long reconcile with recent write",
+			stuckReconcileTimeout: time.Minute,
+			reconcileStartedAt:    now.Add(-time.Hour),
+			lastProgressAt:        now.Add(-time.Second),
+		},
+		{
+			name:                  "write of previous reconcile",
+			stuckReconcileTimeout: time.Minute,
+			reconcileStartedAt:    now.Add(-2 * time.Minute),
+			lastProgressAt:        now.Add(-time.Hour),
+			wantErr:               true,
+		},
+		{
+			name:               "stuck reconcile check disabled",
+			reconcileStartedAt: now.Add(-time.Hour),
+		},
+		{
+			name:            "recent reconcile",
+			resyncPeriod:    time.Minute,
+			leaderSince:     now.Add(-time.Hour),
+			lastReconcileAt: now.Add(-2 * time.Minute),
+		},
+		{
+			name:            "no recent reconcile",
+			resyncPeriod:    time.Minute,
+			leaderSince:     now.Add(-time.Hour),
+			lastReconcileAt: now.Add(-4 * time.Minute),
+			wantErr:         true,
+		},
+		{
+			name:         "no reconcile since election",
+			resyncPeriod: time.Minute,
+			leaderSince:  now.Add(-4 * time.Minute),
+			wantErr:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &SecretReconciler{
+				ResyncPeriod:          tc.resyncPeriod,
+				LivenessResyncPeriods: 3,
+				StuckReconcileTimeout: tc.stuckReconcileTimeout,
+			}
+			if !tc.leaderSince.IsZero() {
+				r.leaderSince.Store(tc.leaderSince.UnixNano())
+			}
+			if !tc.reconcileStartedAt.IsZero() {
+				r.reconcileStartedAt.Store(tc.reconcileStartedAt.UnixNano())
+			}
+			if !tc.lastProgressAt.IsZero() {
+				r.lastProgressAt.Store(tc.lastProgressAt.UnixNano())
+			}
+			if !tc.lastReconcileAt.IsZero() {
+				r.lastReconcileCompletedAt.Store(tc.lastReconcileAt.UnixNano())
+			}
+			err := r.LivenessChecker(nil)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("got error %v, wanted error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
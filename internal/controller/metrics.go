@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// lastSuccessfulReconcile is the unix time in nanoseconds of the last full reconcile without errors
+var lastSuccessfulReconcile atomic.Int64
+
+// startTime is used as time of the last successful full reconcile until the first one completes
+var startTime = time.Now()
+
+var (
+	duplicateWritesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "duplicator_duplicate_writes_total",
+			Help: "Number of create, update and delete operations on duplicate secrets. " +
+				"Operations that were only validated in dry-run mode have the label dry_run=\"true\". " +
+				"The cluster label is the name of the kubeconfig secret of a remote cluster, empty for the local cluster.",
+		},
+		[]string{"cluster", "operation", "dry_run"},
+	)
+	pausedSourcesGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "duplicator_paused_sources",
+			Help: "Number of source secrets with the paused annotation.",
+		},
+	)
+	pausedNamespacesGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "duplicator_paused_namespaces",
+			Help: "Number of namespaces with the paused annotation.",
+		},
+	)
+	rejectedSourcesGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "duplicator_rejected_sources",
+			Help: "Number of source secrets in namespaces that are not allowed to host source secrets.",
+		},
+	)
+	ignoredNamespacesGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "duplicator_ignored_namespaces",
+			Help: "Number of namespaces that opted out of receiving duplicates.",
+		},
+	)
+	remoteClusterUpGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "duplicator_remote_cluster_up",
+			Help: "Whether the last sync of duplicates into a remote cluster succeeded (1) or failed (0).",
+		},
+		[]string{"cluster"},
+	)
+	workloadRestartsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "duplicator_workload_restarts_total",
+			Help: "Number of workloads that were restarted because a duplicate they consume changed.",
+		},
+		[]string{"kind"},
+	)
+	targetFailuresGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "duplicator_target_failures",
+			Help: "Number of consecutive failed writes of a duplicate that is retried with backoff. " +
+				"The version label is the name of a versioned copy, empty for other duplicates.",
+		},
+		[]string{"cluster", "source", "namespace", "version"},
+	)
+	duplicateConflictsGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "duplicator_duplicate_conflicts",
+			Help: "Number of duplicates that can't be created because a secret that isn't a duplicate of " +
+				"the same source secret has their name.",
+		},
+	)
+	sourceCertificateNotAfterGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "duplicator_source_certificate_not_after_seconds",
+			Help: "Expiry of the certificate of a TLS source secret as unix timestamp. " +
+				"Source secrets with invalid certificates aren't reported.",
+		},
+		[]string{"source", "namespace", "name"},
+	)
+	duplicateCertificateNotAfterGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "duplicator_duplicate_certificate_not_after_seconds",
+			Help: "Expiry of the certificate of a duplicate of a TLS source secret in the local cluster " +
+				"as unix timestamp. Duplicates with invalid certificates and duplicates in remote clusters " +
+				"aren't reported.",
+		},
+		[]string{"source", "namespace", "name"},
+	)
+	lastSuccessfulReconcileAgeGauge = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "duplicator_last_successful_reconcile_age_seconds",
+			Help: "Seconds since the last full reconcile without errors, or since the start of the controller " +
+				"if no full reconcile succeeded yet.",
+		},
+		func() float64 {
+			last := startTime
+			if nanos := lastSuccessfulReconcile.Load(); nanos != 0 {
+				last = time.Unix(0, nanos)
+			}
+			return time.Since(last).Seconds()
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		duplicateWritesTotal,
+		pausedSourcesGauge,
+		rejectedSourcesGauge,
+		pausedNamespacesGauge,
+		ignoredNamespacesGauge,
+		remoteClusterUpGauge,
+		targetFailuresGauge,
+		duplicateConflictsGauge,
+		workloadRestartsTotal,
+		sourceCertificateNotAfterGauge,
+		duplicateCertificateNotAfterGauge,
+		lastSuccessfulReconcileAgeGauge,
+	)
+}
+
+func observeSuccessfulReconcile() {
+	lastSuccessfulReconcile.Store(time.Now().UnixNano())
+}
+
+func observeDuplicateWrite(cluster, operation string, dryRun bool) {
+	duplicateWritesTotal.WithLabelValues(cluster, operation, strconv.FormatBool(dryRun)).Inc()
+}
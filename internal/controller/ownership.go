@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// ownershipKeySecretKey is the key of the HMAC key in the ownership key secret
+	ownershipKeySecretKey = "key"
+	ownershipKeySize      = 32
+)
+
+// ownershipKey marks duplicates as owned by this controller.
+// The source annotation alone can't be trusted, because anyone who can annotate a secret could make the controller
+// update or delete it. A duplicate is owned if its owner label is the UID of the ownership key secret and its
+// signature annotation is the HMAC of the owner, its namespace, name and source annotation.
+// The signature can't be forged without read access to the ownership key secret.
+type ownershipKey struct {
+	// owner is the UID of the ownership key secret and identifies the installation of the controller
+	owner string
+	key   []byte
+}
+
+// loadOwnershipKey returns the ownership key from the secret OwnershipKeySecret in the namespace of the controller.
+// A random key is generated if the secret doesn't exist.
+// Duplicates signed with a key become unowned when the ownership key secret is deleted.
+func (r *SecretReconciler) loadOwnershipKey(ctx context.Context, allSecrets *corev1.SecretList) (*ownershipKey, error) {
+	if r.ControllerNamespace == "" || r.OwnershipKeySecret == "" {
+		return nil, newConfigurationError("the controller namespace and ownership key secret must be set")
+	}
+	secret := findControllerSecret(allSecrets, r.ControllerNamespace, r.OwnershipKeySecret)
+	if secret != nil {
+		key := secret.Data[ownershipKeySecretKey]
+		if len(key) < ownershipKeySize {
+			return nil, newConfigurationError("ownership key secret %s/%s must contain at least %d bytes in key %q",
+				r.ControllerNamespace, r.OwnershipKeySecret, ownershipKeySize, ownershipKeySecretKey)
+		}
+		return &ownershipKey{owner: string(secret.UID), key: key}, nil
+	}
+
+	key := make([]byte, ownershipKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	secret = &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      r.OwnershipKeySecret,
+			Namespace: r.ControllerNamespace,
+		},
+		Data: map[string][]byte{ownershipKeySecretKey: key},
+	}
+	if r.DryRun {
+		// Nothing is persisted in dry-run mode, so no existing duplicate can be owned
+		log.FromContext(ctx).Info("dry-run: would create ownership key secret",
+			"secret", client.ObjectKeyFromObject(secret).String())
+		return &ownershipKey{key: key}, nil
+	}
+	// A concurrent create by another replica fails with AlreadyExists and is retried with the key of that replica
+	if err := r.Create(ctx, secret); err != nil {
+		return nil, fmt.Errorf("create ownership key secret %s: %w", client.ObjectKeyFromObject(secret), err)
+	}
+	log.FromContext(ctx).Info("created ownership key secret", "secret", client.ObjectKeyFromObject(secret).String())
+	return &ownershipKey{owner: string(secret.UID), key: key}, nil
+}
+
+// signature returns the HMAC of the owner, namespace, name and source annotation of duplicate.
+func (k *ownershipKey) signature(duplicate *corev1.Secret) string {
+	mac := hmac.New(sha256.New, k.key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", k.owner, duplicate.Namespace, duplicate.Name,
+		duplicate.Annotations[duplicatorFromAnnotationKey])
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sign adds the owner label and signature annotation to duplicate. The source annotation must be set before.
+func (k *ownershipKey) sign(duplicate *corev1.Secret) {
+	if duplicate.Labels == nil {
+		duplicate.Labels = map[string]string{}
+	}
+	duplicate.Labels[duplicatorOwnerLabelKey] = k.owner
+	duplicate.Annotations[duplicatorSignatureAnnotationKey] = k.signature(duplicate)
+}
+
+// owns returns true if duplicate has a valid ownership marker of k.
+func (k *ownershipKey) owns(duplicate *corev1.Secret) bool {
+	signature, ok := duplicate.Annotations[duplicatorSignatureAnnotationKey]
+	return ok && duplicate.Labels[duplicatorOwnerLabelKey] == k.owner &&
+		hmac.Equal([]byte(signature), []byte(k.signature(duplicate)))
+}
+
+// isUnmarked returns true if duplicate has neither owner label nor signature annotation,
+// e.g. because it was created by a version of the controller without ownership markers.
+func isUnmarked(duplicate *corev1.Secret) bool {
+	_, hasLabel := duplicate.Labels[duplicatorOwnerLabelKey]
+	_, hasSignature := duplicate.Annotations[duplicatorSignatureAnnotationKey]
+	return !hasLabel && !hasSignature
+}
+
+// isOwned returns true if duplicate has a valid ownership marker of key or is adopted.
+// Unmarked duplicates are only adopted if AdoptUnmarkedDuplicates is set, and signed with their next update.
+func (r *SecretReconciler) isOwned(key *ownershipKey, duplicate *corev1.Secret) bool {
+	return key.owns(duplicate) || (r.AdoptUnmarkedDuplicates && isUnmarked(duplicate))
+}
+
+// ownedDuplicates returns the duplicates that are owned by the controller.
+// All other secrets with a source annotation are left alone.
+func (r *SecretReconciler) ownedDuplicates(ctx context.Context, key *ownershipKey,
+	duplicates []*corev1.Secret) []*corev1.Secret {
+	owned := make([]*corev1.Secret, 0, len(duplicates))
+	for _, duplicate := range duplicates {
+		if r.isOwned(key, duplicate) {
+			owned = append(owned, duplicate)
+			continue
+		}
+		log.FromContext(ctx).V(1).Info("ignoring secret with source annotation but without valid ownership marker",
+			"secret", client.ObjectKeyFromObject(duplicate).String())
+	}
+	return owned
+}
@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_SecretReconciler_ownedDuplicates(t *testing.T) {
+	ownership := &ownershipKey{owner: "uid-1", key: []byte("0123456789abcdef0123456789abcdef")}
+	signed := func(modify func(duplicate *corev1.Secret)) *corev1.Secret {
+		duplicate := newDuplicateSecret(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "secret1", Namespace: "ns1"},
+		}, "ns2")
+		ownership.sign(duplicate)
+		modify(duplicate)
+		return duplicate
+	}
+
+	testCases := []struct {
+		name      string
+		duplicate *corev1.Secret
+		adopt     bool
+		wantOwned bool
+	}{
+		{
+			name:      "signed",
+			duplicate: signed(func(duplicate *corev1.Secret) {}),
+			wantOwned: true,
+		},
+		{
+			name: "signed with changed data",
+			duplicate: signed(func(duplicate *corev1.Secret) {
+				duplicate.Data = map[string][]byte{"foo": []byte("bar")}
+			}),
+			wantOwned: true,
+		},
+		{
+			name: "source annotation changed",
+			duplicate: signed(func(duplicate *corev1.Secret) {
+				duplicate.Annotations[duplicatorFromAnnotationKey] = "ns1/secret2"
+			}),
+		},
+		{
+			name: "copied into other namespace",
+			duplicate: signed(func(duplicate *corev1.Secret) {
+				duplicate.Namespace = "ns3"
+			}),
+		},
+		{
+			name: "other owner",
+			duplicate: signed(func(duplicate *corev1.Secret) {
+				duplicate.Labels[duplicatorOwnerLabelKey] = "uid-2"
+			}),
+		},
+		{
+			name: "invalid signature",
+			duplicate: signed(func(duplicate *corev1.Secret) {
+				duplicate.Annotations[duplicatorSignatureAnnotationKey] = "invalid"
+			}),
+		},
+		{
+			name: "unmarked",
+			duplicate: signed(func(duplicate *corev1.Secret) {
+				duplicate.Labels = nil
+				delete(duplicate.Annotations, duplicatorSignatureAnnotationKey)
+			}),
+		},
+		{
+			name: "unmarked adopted",
+			duplicate: signed(func(duplicate *corev1.Secret) {
+				duplicate.Labels = nil
+				delete(duplicate.Annotations, duplicatorSignatureAnnotationKey)
+			}),
+			adopt:     true,
+			wantOwned: true,
+		},
+		{
+			name: "invalid signature not adopted",
+			duplicate: signed(func(duplicate *corev1.Secret) {
+				delete(duplicate.Labels, duplicatorOwnerLabelKey)
+				duplicate.Annotations[duplicatorSignatureAnnotationKey] = "invalid"
+			}),
+			adopt: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &SecretReconciler{AdoptUnmarkedDuplicates: tc.adopt}
+			owned := r.ownedDuplicates(context.Background(), ownership, []*corev1.Secret{tc.duplicate})
+			if got := len(owned) == 1; got != tc.wantOwned {
+				t.Errorf("got owned %v, wanted %v", got, tc.wantOwned)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"errors"
+	"sync"
+)
+
+// workerPool runs functions on a bounded number of goroutines and collects their errors.
+type workerPool struct {
+	wg        sync.WaitGroup
+	semaphore chan struct{}
+
+	mu   sync.Mutex
+	errs []error
+}
+
+// newWorkerPool returns a workerPool that runs at most size functions at once.
+// Functions run one at a time if size is less than one.
+func newWorkerPool(size int) *workerPool {
+	return &workerPool{
+		semaphore: make(chan struct{}, max(size, 1)),
+	}
+}
+
+// Go runs f as soon as a worker is available. It blocks while all workers are busy.
+func (p *workerPool) Go(f func() error) {
+	p.semaphore <- struct{}{}
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.semaphore
+			p.wg.Done()
+		}()
+		if err := f(); err != nil {
+			p.mu.Lock()
+			p.errs = append(p.errs, err)
+			p.mu.Unlock()
+		}
+	}()
+}
+
+// Wait waits for all functions to return and returns their errors joined with errors.Join.
+func (p *workerPool) Wait() error {
+	p.wg.Wait()
+	return errors.Join(p.errs...)
+}
@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_workerPool(t *testing.T) {
+	const size = 3
+	pool := newWorkerPool(size)
+	var running, maxRunning atomic.Int32
+	release := make(chan struct{})
+	errA, errB := errors.New("a"), errors.New("b")
+	for i := 0; i < 10; i++ {
+		pool.Go(func() error {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				m := maxRunning.Load()
+				if n <= m || maxRunning.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			if i == size-1 {
+				// All workers are busy, let them finish
+				close(release)
+			}
+			<-release
+			switch i {
+			case 4:
+				return errA
+			case 7:
+				return errB
+			}
+			return nil
+		})
+	}
+
+	err := pool.Wait()
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Errorf("got %v, wanted errors a and b", err)
+	}
+	if maxRunning.Load() > size {
+		t.Errorf("got %d concurrent functions, wanted at most %d", maxRunning.Load(), size)
+	}
+}
@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"cmp"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// recreationTracker tracks the recreated local source secrets whose duplicates are still synced from the previous
+// source secret, so that they are reported in the status annotation. The zero value is ready to use.
+type recreationTracker struct {
+	mu sync.Mutex
+	// previousUIDs contains the UIDs of the previous source secrets found by the current reconcile, by source key
+	previousUIDs map[string]string
+}
+
+// add records that the duplicates of the source secret sourceKey were synced from the source secret previousUID.
+func (t *recreationTracker) add(sourceKey, previousUID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.previousUIDs == nil {
+		t.previousUIDs = make(map[string]string)
+	}
+	t.previousUIDs[sourceKey] = previousUID
+}
+
+// get returns the UID of the previous source secret of the source secret sourceKey,
+// or an empty string if it wasn't recreated.
+func (t *recreationTracker) get(sourceKey string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.previousUIDs[sourceKey]
+}
+
+// reset forgets the recreated source secrets before a reconcile finds them again.
+func (t *recreationTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.previousUIDs = nil
+}
+
+// recreatePolicy returns the policy for the duplicates of source that were synced from the source secret previousUID.
+// The accept-recreated-from annotation of source with the UID of the previous source secret overrides
+// the reject policy, so that the duplicates are updated.
+func (r *SecretReconciler) recreatePolicy(source *corev1.Secret, previousUID string) SourceRecreatePolicy {
+	policy := cmp.Or(r.SourceRecreatePolicy, SourceRecreatePolicyUpdate)
+	if policy == SourceRecreatePolicyReject && source.Annotations[duplicatorAcceptRecreatedFromAnnotationKey] == previousUID {
+		return SourceRecreatePolicyUpdate
+	}
+	return policy
+}
@@ -0,0 +1,291 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	defaultWorkloadRestartQPS   = 1
+	defaultWorkloadRestartBurst = 10
+)
+
+// restartRequest requests a restart of the workloads in namespace that consume the secret.
+type restartRequest struct {
+	namespace string
+	secret    string
+	// source is the source annotation of the duplicate
+	source string
+}
+
+// restartProgress tracks the consumers of a duplicate that were restarted for the pending restart with hash,
+// so that a retry after a partial failure doesn't restart them again.
+type restartProgress struct {
+	hash string
+	// restarted contains the restarted workloads by kind and name
+	restarted map[string]bool
+}
+
+// workloadRestarter restarts the Deployments, StatefulSets and DaemonSets that consume a duplicate after its data
+// changed, like kubectl rollout restart. Restarts are processed in the background and rate limited,
+// so that a change of a source secret doesn't restart all workloads of the cluster at once.
+// A pending restart is recorded in the restart-pending annotation of the duplicate,
+// which is removed after all consumers were restarted, so that it survives a restart of the controller.
+type workloadRestarter struct {
+	client   client.Client
+	reader   client.Reader
+	recorder record.EventRecorder
+	limiter  flowcontrol.RateLimiter
+	queue    workqueue.RateLimitingInterface
+	// progress contains the progress of failed requests. It is only accessed by the worker.
+	progress map[restartRequest]*restartProgress
+}
+
+// newWorkloadRestarter returns a workloadRestarter that patches workloads with c. Workloads are listed with reader
+// instead of the cache, so that the controller doesn't watch all workloads of the cluster.
+func newWorkloadRestarter(c client.Client, reader client.Reader, recorder record.EventRecorder,
+	qps float32, burst int) *workloadRestarter {
+	if qps <= 0 {
+		qps = defaultWorkloadRestartQPS
+	}
+	if burst <= 0 {
+		burst = defaultWorkloadRestartBurst
+	}
+	return &workloadRestarter{
+		client:   c,
+		reader:   reader,
+		recorder: recorder,
+		limiter:  flowcontrol.NewTokenBucketRateLimiter(qps, burst),
+		queue:    workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		progress: make(map[restartRequest]*restartProgress),
+	}
+}
+
+// enqueue requests a restart of the workloads that consume a duplicate. It is safe to call on a nil workloadRestarter.
+func (w *workloadRestarter) enqueue(request restartRequest) {
+	if w == nil {
+		return
+	}
+	w.queue.Add(request)
+}
+
+// Start processes restart requests until ctx is done.
+func (w *workloadRestarter) Start(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		w.queue.ShutDown()
+	}()
+	for w.processNext(ctx) {
+	}
+	return nil
+}
+
+func (w *workloadRestarter) processNext(ctx context.Context) bool {
+	item, shutdown := w.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer w.queue.Done(item)
+	request := item.(restartRequest)
+	// A failed request is retried with the workloads that weren't restarted yet
+	err := w.restartWorkloads(ctx, request)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to restart workloads", "namespace", request.namespace,
+			"secret", request.secret)
+		w.queue.AddRateLimited(item)
+		return true
+	}
+	w.queue.Forget(item)
+	return true
+}
+
+// restartWorkloads restarts the workloads in the namespace of request that consume the secret of request,
+// if the duplicate has a pending restart, and removes the pending restart after all of them were restarted.
+// The duplicate is read without cache, so that a request for a restart that was completed meanwhile does nothing.
+func (w *workloadRestarter) restartWorkloads(ctx context.Context, request restartRequest) error {
+	duplicate := &corev1.Secret{}
+	err := w.reader.Get(ctx, client.ObjectKey{Namespace: request.namespace, Name: request.secret}, duplicate)
+	if k8sErrors.IsNotFound(err) {
+		delete(w.progress, request)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	hash, pending := duplicate.Annotations[duplicatorRestartPendingAnnotationKey]
+	if !pending {
+		delete(w.progress, request)
+		return nil
+	}
+	progress := w.progress[request]
+	if progress == nil || progress.hash != hash {
+		// A new pending restart restarts all consumers, also after the duplicate changed again
+		progress = &restartProgress{hash: hash, restarted: make(map[string]bool)}
+		w.progress[request] = progress
+	}
+
+	workloads, err := findConsumers(ctx, w.reader, request.namespace, request.secret)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, workload := range workloads {
+		id := kindOf(workload) + "/" + workload.GetName()
+		if progress.restarted[id] {
+			continue
+		}
+		if err := w.restart(ctx, workload, request); err != nil {
+			errs = append(errs, fmt.Errorf("restart %T %s: %w", workload, client.ObjectKeyFromObject(workload), err))
+			continue
+		}
+		progress.restarted[id] = true
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	// The optimistic lock keeps a pending restart that was recorded by an update in the meantime
+	patch := client.MergeFromWithOptions(duplicate.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	delete(duplicate.Annotations, duplicatorRestartPendingAnnotationKey)
+	if err := w.client.Patch(ctx, duplicate, patch); err != nil && !k8sErrors.IsNotFound(err) {
+		return fmt.Errorf("remove pending restart of duplicate %s: %w", client.ObjectKeyFromObject(duplicate), err)
+	}
+	delete(w.progress, request)
+	return nil
+}
+
+// findConsumers returns the Deployments, StatefulSets and DaemonSets in namespace that reference the secret.
+func findConsumers(ctx context.Context, reader client.Reader, namespace, secret string) ([]client.Object, error) {
+	workloads, err := listWorkloads(ctx, reader, client.InNamespace(namespace))
+	if err != nil {
+		return nil, err
+	}
+	return filterConsumers(workloads, secret), nil
+}
+
+// listWorkloads returns the Deployments, StatefulSets and DaemonSets listed with opts.
+func listWorkloads(ctx context.Context, reader client.Reader, opts ...client.ListOption) ([]client.Object, error) {
+	var workloads []client.Object
+	deployments := &appsv1.DeploymentList{}
+	if err := reader.List(ctx, deployments, opts...); err != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		workloads = append(workloads, &deployments.Items[i])
+	}
+	statefulSets := &appsv1.StatefulSetList{}
+	if err := reader.List(ctx, statefulSets, opts...); err != nil {
+		return nil, err
+	}
+	for i := range statefulSets.Items {
+		workloads = append(workloads, &statefulSets.Items[i])
+	}
+	daemonSets := &appsv1.DaemonSetList{}
+	if err := reader.List(ctx, daemonSets, opts...); err != nil {
+		return nil, err
+	}
+	for i := range daemonSets.Items {
+		workloads = append(workloads, &daemonSets.Items[i])
+	}
+	return workloads, nil
+}
+
+// filterConsumers returns the workloads that reference the secret.
+func filterConsumers(workloads []client.Object, secret string) []client.Object {
+	var consumers []client.Object
+	for _, workload := range workloads {
+		if podSpecReferencesSecret(&podTemplate(workload).Spec, secret) {
+			consumers = append(consumers, workload)
+		}
+	}
+	return consumers
+}
+
+// restart patches the restart annotation of the pod template of workload, which rolls out new pods.
+func (w *workloadRestarter) restart(ctx context.Context, workload client.Object, request restartRequest) error {
+	if err := w.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	patch := client.MergeFrom(workload.DeepCopyObject().(client.Object))
+	template := podTemplate(workload)
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[duplicatorRestartedAtAnnotationKey] = time.Now().Format(time.RFC3339)
+	if err := w.client.Patch(ctx, workload, patch); err != nil {
+		return err
+	}
+	kind := kindOf(workload)
+	workloadRestartsTotal.WithLabelValues(kind).Inc()
+	log.FromContext(ctx).Info("restarted workload", "kind", kind,
+		"workload", client.ObjectKeyFromObject(workload).String(), "secret", request.secret)
+	w.recorder.Eventf(workload, corev1.EventTypeNormal, reasonWorkloadRestarted,
+		"Restarted because secret %s changed, duplicate of %s", request.secret, request.source)
+	return nil
+}
+
+// podTemplate returns the pod template of a Deployment, StatefulSet or DaemonSet.
+func podTemplate(workload client.Object) *corev1.PodTemplateSpec {
+	switch w := workload.(type) {
+	case *appsv1.Deployment:
+		return &w.Spec.Template
+	case *appsv1.StatefulSet:
+		return &w.Spec.Template
+	case *appsv1.DaemonSet:
+		return &w.Spec.Template
+	}
+	panic(fmt.Sprintf("unsupported workload %T", workload))
+}
+
+func kindOf(workload client.Object) string {
+	switch workload.(type) {
+	case *appsv1.Deployment:
+		return "Deployment"
+	case *appsv1.StatefulSet:
+		return "StatefulSet"
+	case *appsv1.DaemonSet:
+		return "DaemonSet"
+	}
+	return fmt.Sprintf("%T", workload)
+}
+
+// podSpecReferencesSecret returns true if spec mounts the secret as volume or reads it in environment variables.
+func podSpecReferencesSecret(spec *corev1.PodSpec, secret string) bool {
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil && volume.Secret.SecretName == secret {
+			return true
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.Secret != nil && source.Secret.Name == secret {
+					return true
+				}
+			}
+		}
+	}
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, container := range containers {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil && envFrom.SecretRef.Name == secret {
+				return true
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil && env.ValueFrom.SecretKeyRef.Name == secret {
+				return true
+			}
+		}
+	}
+	return false
+}
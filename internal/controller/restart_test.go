@@ -0,0 +1,222 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func Test_podSpecReferencesSecret(t *testing.T) {
+	testCases := []struct {
+		name string
+		spec corev1.PodSpec
+		want bool
+	}{
+		{
+			name: "no reference",
+			spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		},
+		{
+			name: "secret volume",
+			spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+				VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "secret1"}},
+			}}},
+			want: true,
+		},
+		{
+			name: "projected volume",
+			spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+				VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{
+					Sources: []corev1.VolumeProjection{{Secret: &corev1.SecretProjection{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "secret1"},
+					}}},
+				}},
+			}}},
+			want: true,
+		},
+		{
+			name: "envFrom in init container",
+			spec: corev1.PodSpec{InitContainers: []corev1.Container{{
+				EnvFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "secret1"},
+				}}},
+			}}},
+			want: true,
+		},
+		{
+			name: "env valueFrom",
+			spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Env: []corev1.EnvVar{{ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "secret1"},
+				}}}},
+			}}},
+			want: true,
+		},
+		{
+			name: "other secret",
+			spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+				VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "secret2"}},
+			}}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := podSpecReferencesSecret(&tc.spec, "secret1")
+			if got != tc.want {
+				t.Errorf("got %v, wanted %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func Test_workloadRestarter_restartWorkloads(t *testing.T) {
+	template := func(secret string) corev1.PodTemplateSpec {
+		return corev1.PodTemplateSpec{Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: secret}},
+		}}}}
+	}
+	duplicate := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "secret1",
+			Namespace:   "ns1",
+			Annotations: map[string]string{duplicatorRestartPendingAnnotationKey: "hash-1"},
+		},
+	}
+	consumer := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "consumer", Namespace: "ns1"},
+		Spec:       appsv1.DeploymentSpec{Template: template("secret1")},
+	}
+	failingConsumer := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "failing-consumer", Namespace: "ns1"},
+		Spec:       appsv1.StatefulSetSpec{Template: template("secret1")},
+	}
+	otherNamespace := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "other-namespace", Namespace: "ns2"},
+		Spec:       appsv1.StatefulSetSpec{Template: template("secret1")},
+	}
+	otherSecret := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "other-secret", Namespace: "ns1"},
+		Spec:       appsv1.DaemonSetSpec{Template: template("secret2")},
+	}
+	// The first restart of failingConsumer fails
+	failed := false
+	c := fake.NewClientBuilder().
+		WithObjects(duplicate, consumer, failingConsumer, otherNamespace, otherSecret).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch,
+				opts ...client.PatchOption) error {
+				if obj.GetName() == failingConsumer.Name && !failed {
+					failed = true
+					return errors.New("unavailable")
+				}
+				return c.Patch(ctx, obj, patch, opts...)
+			},
+		}).
+		Build()
+	recorder := record.NewFakeRecorder(10)
+	w := newWorkloadRestarter(c, c, recorder, 0, 0)
+	request := restartRequest{namespace: "ns1", secret: "secret1", source: "ns/secret1"}
+	wantEvent := "Normal WorkloadRestarted Restarted because secret secret1 changed, duplicate of ns/secret1"
+
+	// The consumers that were restarted before a failure aren't restarted again by the retry,
+	// and requests after the pending restart was completed do nothing
+	for i, wantErr := range []bool{true, false, false} {
+		err := w.restartWorkloads(context.Background(), request)
+		if (err != nil) != wantErr {
+			t.Fatalf("attempt %d: got error %v, wanted error %v", i, err, wantErr)
+		}
+		var wantEvents []string
+		if i < 2 {
+			wantEvents = []string{wantEvent}
+		}
+		if events := recordedEvents(recorder); !reflect.DeepEqual(events, wantEvents) {
+			t.Errorf("attempt %d: got events %v, wanted %v", i, events, wantEvents)
+		}
+	}
+
+	for _, tc := range []struct {
+		workload    client.Object
+		key         client.ObjectKey
+		wantRestart bool
+	}{
+		{workload: &appsv1.Deployment{}, key: client.ObjectKeyFromObject(consumer), wantRestart: true},
+		{workload: &appsv1.StatefulSet{}, key: client.ObjectKeyFromObject(failingConsumer), wantRestart: true},
+		{workload: &appsv1.StatefulSet{}, key: client.ObjectKeyFromObject(otherNamespace)},
+		{workload: &appsv1.DaemonSet{}, key: client.ObjectKeyFromObject(otherSecret)},
+	} {
+		if err := c.Get(context.Background(), tc.key, tc.workload); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, restarted := podTemplate(tc.workload).Annotations[duplicatorRestartedAtAnnotationKey]
+		if restarted != tc.wantRestart {
+			t.Errorf("%s %s: got restarted %v, wanted %v", kindOf(tc.workload), tc.key, restarted, tc.wantRestart)
+		}
+	}
+	got := &corev1.Secret{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(duplicate), got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, pending := got.Annotations[duplicatorRestartPendingAnnotationKey]; pending {
+		t.Errorf("got pending restart after all consumers were restarted")
+	}
+}
+
+func Test_markRestartPending(t *testing.T) {
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "secret1",
+			Namespace:   "ns1",
+			Annotations: map[string]string{duplicatorRestartWorkloadsAnnotationKey: "true"},
+		},
+		Data: map[string][]byte{"foo": []byte("new")},
+	}
+	outdated := newDuplicateSecret(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "secret1", Namespace: "ns1"},
+		Data:       map[string][]byte{"foo": []byte("old")},
+	}, "ns2")
+	pending := newDuplicateSecret(source, "ns2")
+	pending.Annotations[duplicatorRestartPendingAnnotationKey] = "hash-1"
+
+	testCases := []struct {
+		name        string
+		target      targetCluster
+		duplicate   *corev1.Secret
+		wantPending string
+	}{
+		{
+			name:        "data changes",
+			duplicate:   outdated,
+			wantPending: sourceContentHash(source),
+		},
+		{
+			name:        "pending restart is kept",
+			duplicate:   pending,
+			wantPending: "hash-1",
+		},
+		{
+			name:      "remote cluster",
+			target:    targetCluster{name: "edge"},
+			duplicate: outdated,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			updated := newDuplicateSecret(source, "ns2")
+			markRestartPending(tc.target, source, tc.duplicate, updated)
+			if got := updated.Annotations[duplicatorRestartPendingAnnotationKey]; got != tc.wantPending {
+				t.Errorf("got pending restart %q, wanted %q", got, tc.wantPending)
+			}
+		})
+	}
+}
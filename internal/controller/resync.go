@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// resync enqueues a full reconcile every ResyncPeriod until ctx is done.
+// It runs as leader election runnable, so only the leader resyncs.
+func (r *SecretReconciler) resync(ctx context.Context) error {
+	r.leaderSince.Store(time.Now().UnixNano())
+	ticker := time.NewTicker(r.ResyncPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			log.FromContext(ctx).V(2).Info("enqueueing periodic full reconcile")
+			r.enqueueFullReconcile()
+		}
+	}
+}
+
+// ResyncChecker is a readiness check that fails if the leader hasn't completed a full reconcile
+// within two resync periods. Reconciles that completed with errors of single duplicates, source secrets
+// or remote clusters count as completed, so that invalid annotations or an unreachable remote cluster
+// don't make the leader unready. Replicas that aren't the leader are always ready.
+func (r *SecretReconciler) ResyncChecker(_ *http.Request) error {
+	leaderSince := r.leaderSince.Load()
+	if r.ResyncPeriod <= 0 || leaderSince == 0 {
+		return nil
+	}
+	last := max(r.lastFullReconcileAt.Load(), leaderSince)
+	age := time.Since(time.Unix(0, last))
+	if age > 2*r.ResyncPeriod {
+		return fmt.Errorf("no full reconcile completed for %s", age.Round(time.Second))
+	}
+	return nil
+}
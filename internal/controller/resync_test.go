@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_SecretReconciler_ResyncChecker(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		name              string
+		resyncPeriod      time.Duration
+		leaderSince       time.Time
+		lastFullReconcile time.Time
+		wantErr           bool
+	}{
+		{
+			name:        "resync disabled",
+			leaderSince: now.Add(-time.Hour),
+		},
+		{
+			name:         "not leader",
+			resyncPeriod: time.Minute,
+		},
+		{
+			name:         "recently elected",
+			resyncPeriod: time.Minute,
+			leaderSince:  now.Add(-time.Minute),
+		},
+		{
+			name:              "recent full reconcile",
+			resyncPeriod:      time.Minute,
+			leaderSince:       now.Add(-time.Hour),
+			lastFullReconcile: now.Add(-time.Minute),
+		},
+		{
+			name:              "stale full reconcile",
+			resyncPeriod:      time.Minute,
+			leaderSince:       now.Add(-time.Hour),
+			lastFullReconcile: now.Add(-3 * time.Minute),
+			wantErr:           true,
+		},
+		{
+			name:         "no full reconcile since election",
+			resyncPeriod: time.Minute,
+			leaderSince:  now.Add(-3 * time.Minute),
+			wantErr:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &SecretReconciler{ResyncPeriod: tc.resyncPeriod}
+			if !tc.leaderSince.IsZero() {
+				r.leaderSince.Store(tc.leaderSince.UnixNano())
+			}
+			if !tc.lastFullReconcile.IsZero() {
+				r.lastFullReconcileAt.Store(tc.lastFullReconcile.UnixNano())
+			}
+			err := r.ResyncChecker(nil)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("got error %v, wanted error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func Test_SecretReconciler_ResyncChecker_sourceErrors(t *testing.T) {
+	// A source secret that references an unknown remote cluster fails every reconcile
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret1",
+			Namespace: "ns1",
+			Annotations: map[string]string{
+				duplicatorDuplicateAnnotationKey: "true",
+				duplicatorClustersAnnotationKey:  "typo",
+			},
+		},
+	}
+	c := fake.NewClientBuilder().WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}},
+		source,
+	).Build()
+	r := &SecretReconciler{
+		Client:              c,
+		Recorder:            record.NewFakeRecorder(10),
+		ControllerNamespace: "ns1",
+		OwnershipKeySecret:  "ownership-key",
+		ResyncPeriod:        time.Minute,
+	}
+	r.leaderSince.Store(time.Now().Add(-time.Hour).UnixNano())
+	lastSuccessfulReconcile.Store(0)
+
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lastSuccessfulReconcile.Load() != 0 {
+		t.Errorf("got successful reconcile, wanted the reconcile to fail")
+	}
+	if err := r.ResyncChecker(nil); err != nil {
+		t.Errorf("got error %v, wanted ready leader", err)
+	}
+}
@@ -0,0 +1,230 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	historySecretPrefix = "k8s-duplicator-history-"
+	// maxHistorySize keeps history secrets well below the size limit of secrets.
+	// The newest revision is kept even if it is larger.
+	maxHistorySize = 512 * 1024
+	// minRevisionPrefix is the minimum length of an abbreviated revision in the pin-revision annotation
+	minRevisionPrefix = 8
+)
+
+// revision is a version of the content of a source secret. Revisions are stored as JSON in a history secret
+// in the namespace of the controller, keyed by their revision hash.
+type revision struct {
+	Type      corev1.SecretType `json:"type,omitempty"`
+	Data      map[string][]byte `json:"data,omitempty"`
+	CreatedAt v1.Time           `json:"createdAt"`
+}
+
+// sourceRevision returns the revision hash of the content of source.
+// Unlike the content hash, it doesn't change when the source secret is recreated with the same content.
+func sourceRevision(source *corev1.Secret) string {
+	return contentHash(source.Data, source.Type)
+}
+
+// historySecretName returns the name of the history secret of the source secret sourceKey.
+// The name is derived from a hash because namespace and name of the source secret may be too long for a name.
+func historySecretName(sourceKey string) string {
+	hash := sha256.Sum256([]byte(sourceKey))
+	return historySecretPrefix + hex.EncodeToString(hash[:])[:16]
+}
+
+// findHistorySecrets returns the history secrets in namespace by the key of their source secret.
+// Secrets with a history annotation whose name doesn't match their source secret aren't history secrets,
+// so that the controller never deletes other secrets in its namespace.
+func findHistorySecrets(allSecrets *corev1.SecretList, namespace string) map[string]*corev1.Secret {
+	histories := make(map[string]*corev1.Secret)
+	for i := range allSecrets.Items {
+		secret := &allSecrets.Items[i]
+		sourceKey, ok := secret.Annotations[duplicatorHistoryOfAnnotationKey]
+		if ok && secret.Namespace == namespace && secret.Name == historySecretName(sourceKey) {
+			histories[sourceKey] = secret
+		}
+	}
+	return histories
+}
+
+// parseHistory returns the revisions stored in a history secret by revision hash.
+// Invalid revisions are skipped and dropped with the next update of the history secret.
+func parseHistory(history *corev1.Secret) map[string]revision {
+	revisions := make(map[string]revision)
+	if history == nil {
+		return revisions
+	}
+	for hash, value := range history.Data {
+		var rev revision
+		if err := json.Unmarshal(value, &rev); err == nil {
+			revisions[hash] = rev
+		}
+	}
+	return revisions
+}
+
+// isPinResolved returns true if source has no pin-revision annotation or its content is the pinned revision.
+// The duplicates of a source secret whose pin can't be resolved are left untouched.
+func isPinResolved(source *corev1.Secret) bool {
+	pin, ok := source.Annotations[duplicatorPinRevisionAnnotationKey]
+	return !ok || strings.HasPrefix(sourceRevision(source), pin)
+}
+
+// resolvePins returns allSources with the content of pinned source secrets replaced by their pinned revision,
+// and the reasons why pins couldn't be resolved by source key.
+func (r *SecretReconciler) resolvePins(ctx context.Context, allSources []*corev1.Secret,
+	histories map[string]*corev1.Secret) ([]*corev1.Secret, map[string]string) {
+	resolved := make([]*corev1.Secret, 0, len(allSources))
+	pinErrors := make(map[string]string)
+	for _, source := range allSources {
+		pin, ok := source.Annotations[duplicatorPinRevisionAnnotationKey]
+		if !ok || validateSecretAnnotations(source) != nil || isPinResolved(source) {
+			resolved = append(resolved, source)
+			continue
+		}
+		key := client.ObjectKeyFromObject(source).String()
+		var matches []string
+		revisions := parseHistory(histories[key])
+		for hash := range revisions {
+			if strings.HasPrefix(hash, pin) {
+				matches = append(matches, hash)
+			}
+		}
+		if len(matches) != 1 {
+			message := fmt.Sprintf("revision %s not found in the revision history", pin)
+			if len(matches) > 1 {
+				message = fmt.Sprintf("revision %s is ambiguous, it matches %d revisions", pin, len(matches))
+			}
+			pinErrors[key] = message
+			// The event is only recorded when the pin error in the status changes
+			if currentSourceStatus(source).PinError != message {
+				log.FromContext(ctx).Info("can't resolve pinned revision", "source", key, "reason", message)
+				r.Recorder.Event(source, corev1.EventTypeWarning, reasonRevisionNotFound,
+					"Duplicates are left untouched: "+message)
+			}
+			resolved = append(resolved, source)
+			continue
+		}
+		// The copy is only used to sync duplicates and report the status, the source secret itself isn't changed
+		pinned := source.DeepCopy()
+		pinned.Data = revisions[matches[0]].Data
+		pinned.Type = revisions[matches[0]].Type
+		resolved = append(resolved, pinned)
+	}
+	return resolved, pinErrors
+}
+
+// reconcileHistories records the current content of allSources in their history secrets
+// and deletes the history secrets of source secrets that don't exist anymore.
+// The content of paused, invalid, pinned and rejected source secrets isn't recorded because it isn't synced.
+func (r *SecretReconciler) reconcileHistories(ctx context.Context, allSources, rejectedSources []*corev1.Secret,
+	histories map[string]*corev1.Secret) error {
+	var errs []error
+	existing := make(map[string]bool)
+	for _, source := range rejectedSources {
+		existing[client.ObjectKeyFromObject(source).String()] = true
+	}
+	for _, source := range allSources {
+		key := client.ObjectKeyFromObject(source).String()
+		existing[key] = true
+		_, pinned := source.Annotations[duplicatorPinRevisionAnnotationKey]
+		if r.RevisionHistoryLimit <= 0 || pinned || r.isSourceFrozen(source) {
+			continue
+		}
+		if err := r.recordRevision(ctx, key, source, histories[key]); err != nil {
+			errs = append(errs, fmt.Errorf("record revision of source %s: %w", key, err))
+		}
+	}
+
+	for key, history := range histories {
+		if existing[key] {
+			continue
+		}
+		if r.DryRun {
+			log.FromContext(ctx).Info("dry-run: would delete revision history of deleted source", "source", key)
+			continue
+		}
+		if err := r.Delete(ctx, history); err != nil && !k8sErrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("delete revision history of source %s: %w", key, err))
+			continue
+		}
+		log.FromContext(ctx).Info("deleted revision history of deleted source", "source", key)
+	}
+	return errors.Join(errs...)
+}
+
+// recordRevision adds the content of source to its history secret if it isn't recorded yet.
+// The oldest revisions are dropped when the history exceeds RevisionHistoryLimit or maxHistorySize.
+func (r *SecretReconciler) recordRevision(ctx context.Context, key string, source, history *corev1.Secret) error {
+	hash := sourceRevision(source)
+	revisions := parseHistory(history)
+	if _, ok := revisions[hash]; ok {
+		return nil
+	}
+	revisions[hash] = revision{Type: source.Type, Data: source.Data, CreatedAt: v1.Time{Time: time.Now()}}
+
+	hashes := make([]string, 0, len(revisions))
+	for h := range revisions {
+		hashes = append(hashes, h)
+	}
+	// Newest first
+	sort.Slice(hashes, func(i, j int) bool {
+		return revisions[hashes[i]].CreatedAt.After(revisions[hashes[j]].CreatedAt.Time)
+	})
+	data := make(map[string][]byte)
+	size := 0
+	for i, h := range hashes {
+		encoded, err := json.Marshal(revisions[h])
+		if err != nil {
+			return err
+		}
+		size += len(h) + len(encoded)
+		if i >= r.RevisionHistoryLimit || (i > 0 && size > maxHistorySize) {
+			break
+		}
+		data[h] = encoded
+	}
+
+	if r.DryRun {
+		log.FromContext(ctx).Info("dry-run: would record revision", "source", key, "revision", hash)
+		return nil
+	}
+	if history == nil {
+		history = &corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{
+				Name:        historySecretName(key),
+				Namespace:   r.ControllerNamespace,
+				Annotations: map[string]string{duplicatorHistoryOfAnnotationKey: key},
+			},
+			Data: data,
+		}
+		if err := r.Create(ctx, history); err != nil {
+			return err
+		}
+	} else {
+		history = history.DeepCopy()
+		history.Data = data
+		// A conflict with a concurrent update is retried with the next reconcile
+		if err := r.Update(ctx, history); err != nil {
+			return err
+		}
+	}
+	log.FromContext(ctx).Info("recorded revision", "source", key, "revision", hash)
+	return nil
+}
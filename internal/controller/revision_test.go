@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newHistorySecret(sourceKey string, revisions ...revision) *corev1.Secret {
+	history := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        historySecretName(sourceKey),
+			Namespace:   "duplicator",
+			Annotations: map[string]string{duplicatorHistoryOfAnnotationKey: sourceKey},
+		},
+		Data: map[string][]byte{},
+	}
+	for _, rev := range revisions {
+		encoded, _ := json.Marshal(rev)
+		history.Data[contentHash(rev.Data, rev.Type)] = encoded
+	}
+	return history
+}
+
+func Test_SecretReconciler_resolvePins(t *testing.T) {
+	previous := revision{Data: map[string][]byte{"foo": []byte("previous")}, Type: corev1.SecretTypeOpaque}
+	previousHash := contentHash(previous.Data, previous.Type)
+	newSource := func(pin string) *corev1.Secret {
+		source := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "secret1",
+				Namespace:   "ns1",
+				Annotations: map[string]string{duplicatorDuplicateAnnotationKey: "true"},
+			},
+			Data: map[string][]byte{"foo": []byte("current")},
+			Type: corev1.SecretTypeOpaque,
+		}
+		if pin != "" {
+			source.Annotations[duplicatorPinRevisionAnnotationKey] = pin
+		}
+		return source
+	}
+	histories := map[string]*corev1.Secret{"ns1/secret1": newHistorySecret("ns1/secret1", previous)}
+	reported := newSource("0123456789abcdef")
+	reported.Annotations[duplicatorStatusAnnotationKey] =
+		`{"pinError":"revision 0123456789abcdef not found in the revision history"}`
+
+	testCases := []struct {
+		name         string
+		source       *corev1.Secret
+		wantData     string
+		wantFrozen   bool
+		wantPinError string
+		wantEvents   int
+	}{
+		{
+			name:     "not pinned",
+			source:   newSource(""),
+			wantData: "current",
+		},
+		{
+			name:     "pinned to previous revision",
+			source:   newSource(previousHash),
+			wantData: "previous",
+		},
+		{
+			name:     "pinned to abbreviated previous revision",
+			source:   newSource(previousHash[:8]),
+			wantData: "previous",
+		},
+		{
+			name:     "pinned to current revision",
+			source:   newSource(sourceRevision(newSource(""))),
+			wantData: "current",
+		},
+		{
+			name:         "pinned to unknown revision",
+			source:       newSource("0123456789abcdef"),
+			wantData:     "current",
+			wantFrozen:   true,
+			wantPinError: "revision 0123456789abcdef not found in the revision history",
+			wantEvents:   1,
+		},
+		{
+			name:         "pinned to unknown revision reported in status",
+			source:       reported,
+			wantData:     "current",
+			wantFrozen:   true,
+			wantPinError: "revision 0123456789abcdef not found in the revision history",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			r := &SecretReconciler{Recorder: recorder}
+			resolved, pinErrors := r.resolvePins(context.Background(), []*corev1.Secret{tc.source}, histories)
+			if got := string(resolved[0].Data["foo"]); got != tc.wantData {
+				t.Errorf("got data %q, wanted %q", got, tc.wantData)
+			}
+			if got := r.isSourceFrozen(resolved[0]); got != tc.wantFrozen {
+				t.Errorf("got frozen %v, wanted %v", got, tc.wantFrozen)
+			}
+			if got := pinErrors["ns1/secret1"]; got != tc.wantPinError {
+				t.Errorf("got pin error %q, wanted %q", got, tc.wantPinError)
+			}
+			if got := string(tc.source.Data["foo"]); got != "current" {
+				t.Errorf("source secret was modified, got data %q", got)
+			}
+			if got := recordedEvents(recorder); len(got) != tc.wantEvents {
+				t.Errorf("got events %v, wanted %d", got, tc.wantEvents)
+			}
+		})
+	}
+}
+
+func Test_findHistorySecrets(t *testing.T) {
+	history := newHistorySecret("ns1/secret1")
+	renamed := newHistorySecret("ns1/secret2")
+	renamed.Name = "unrelated"
+	otherNamespace := newHistorySecret("ns1/secret3")
+	otherNamespace.Namespace = "other"
+	allSecrets := &corev1.SecretList{Items: []corev1.Secret{*history, *renamed, *otherNamespace}}
+
+	got := findHistorySecrets(allSecrets, "duplicator")
+	if len(got) != 1 || got["ns1/secret1"] == nil || got["ns1/secret1"].Name != history.Name {
+		t.Errorf("got history secrets %v, wanted only the history of ns1/secret1", got)
+	}
+}
+
+func Test_SecretReconciler_reconcileHistories(t *testing.T) {
+	newSource := func(name, data string, annotations map[string]string) *corev1.Secret {
+		source := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "ns1",
+				Annotations: map[string]string{duplicatorDuplicateAnnotationKey: "true"},
+			},
+			Data: map[string][]byte{"foo": []byte(data)},
+		}
+		for key, value := range annotations {
+			source.Annotations[key] = value
+		}
+		return source
+	}
+	oldest := revision{Data: map[string][]byte{"foo": []byte("v1")}, CreatedAt: metav1.NewTime(time.Now().Add(-2 * time.Hour))}
+	older := revision{Data: map[string][]byte{"foo": []byte("v2")}, CreatedAt: metav1.NewTime(time.Now().Add(-time.Hour))}
+
+	changed := newSource("changed", "v3", nil)
+	unchanged := newSource("unchanged", "v1", nil)
+	pinned := newSource("pinned", "v3", map[string]string{duplicatorPinRevisionAnnotationKey: sourceRevision(unchanged)})
+	paused := newSource("paused", "v3", map[string]string{duplicatorPausedAnnotationKey: "true"})
+	created := newSource("created", "v1", nil)
+	histories := map[string]*corev1.Secret{
+		"ns1/changed":   newHistorySecret("ns1/changed", oldest, older),
+		"ns1/unchanged": newHistorySecret("ns1/unchanged", oldest),
+		"ns1/pinned":    newHistorySecret("ns1/pinned", oldest),
+		"ns1/paused":    newHistorySecret("ns1/paused", oldest),
+		"ns1/deleted":   newHistorySecret("ns1/deleted", oldest),
+	}
+	var objects []client.Object
+	for _, history := range histories {
+		objects = append(objects, history.DeepCopy())
+	}
+	c := fake.NewClientBuilder().WithObjects(objects...).Build()
+	r := &SecretReconciler{Client: c, ControllerNamespace: "duplicator", RevisionHistoryLimit: 2}
+	for key, history := range histories {
+		// The fake client sets the resource version of created objects
+		if err := c.Get(context.Background(), client.ObjectKeyFromObject(history), history); err != nil {
+			t.Fatalf("get history of %s: %v", key, err)
+		}
+	}
+
+	err := r.reconcileHistories(context.Background(), []*corev1.Secret{changed, unchanged, pinned, paused, created},
+		nil, histories)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, tc := range []struct {
+		source        string
+		wantRevisions []string
+	}{
+		{source: "ns1/changed", wantRevisions: []string{"v2", "v3"}},
+		{source: "ns1/unchanged", wantRevisions: []string{"v1"}},
+		{source: "ns1/pinned", wantRevisions: []string{"v1"}},
+		{source: "ns1/paused", wantRevisions: []string{"v1"}},
+		{source: "ns1/created", wantRevisions: []string{"v1"}},
+		{source: "ns1/deleted"},
+	} {
+		history := &corev1.Secret{}
+		err := c.Get(context.Background(), client.ObjectKey{Namespace: "duplicator", Name: historySecretName(tc.source)},
+			history)
+		if client.IgnoreNotFound(err) != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err == nil && history.Annotations[duplicatorHistoryOfAnnotationKey] != tc.source {
+			t.Errorf("%s: got history of %q", tc.source, history.Annotations[duplicatorHistoryOfAnnotationKey])
+		}
+		var got []string
+		for _, rev := range parseHistory(history) {
+			got = append(got, string(rev.Data["foo"]))
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tc.wantRevisions) {
+			t.Errorf("%s: got revisions %v, wanted %v", tc.source, got, tc.wantRevisions)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package controller
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	defaultRolloutBakeTime = 10 * time.Minute
+	// rolloutCheckInterval is the interval of health checks while a staged rollout waits for ready workloads
+	rolloutCheckInterval = 10 * time.Second
+)
+
+// rolloutStatus is the progress of a staged rollout of a source secret, reported in its status annotation.
+// Namespaces are assigned to waves with the rollout-wave label. Changes of the source secret are applied to the
+// duplicates in the namespaces of one wave after the other, starting with the lowest wave.
+// Namespaces without valid label are in the last wave.
+type rolloutStatus struct {
+	// Hash is the content hash of the source secret that is rolled out
+	Hash string `json:"hash"`
+	// Wave is the highest wave whose duplicates are updated
+	Wave int `json:"wave"`
+	// WaveCompletedAt is the time at which all duplicates of the current wave were updated
+	WaveCompletedAt *v1.Time `json:"waveCompletedAt,omitempty"`
+	// Completed is true if the duplicates in all waves are updated
+	Completed bool `json:"completed,omitempty"`
+	// Waiting explains why the rollout doesn't proceed to the next wave
+	Waiting string `json:"waiting,omitempty"`
+}
+
+// isStagedRollout returns true if changes of source are rolled out in waves.
+func isStagedRollout(source *corev1.Secret) bool {
+	return source.Annotations[duplicatorStagedRolloutAnnotationKey] == "true"
+}
+
+// namespaceWaves returns the rollout wave of each namespace.
+func namespaceWaves(allNamespaces []*corev1.Namespace) map[string]int {
+	waves := make(map[string]int, len(allNamespaces))
+	lastWave := 0
+	var unlabeled []string
+	for _, namespace := range allNamespaces {
+		wave, err := strconv.Atoi(namespace.Labels[duplicatorRolloutWaveLabelKey])
+		if err != nil || wave < 0 {
+			unlabeled = append(unlabeled, namespace.Name)
+			continue
+		}
+		waves[namespace.Name] = wave
+		lastWave = max(lastWave, wave+1)
+	}
+	for _, name := range unlabeled {
+		waves[name] = lastWave
+	}
+	return waves
+}
+
+// isInRolloutWave returns true if the duplicate of a source secret in namespace may be updated.
+func isInRolloutWave(rollout *rolloutStatus, waves map[string]int, namespace string) bool {
+	return rollout == nil || rollout.Completed || waves[namespace] <= rollout.Wave
+}
+
+// advanceRollouts returns the progress of the staged rollouts of allSources in the local cluster by source key,
+// and the delay after which the rollouts must be checked again, or zero if they proceed with the next change.
+// A rollout proceeds to the next wave when all duplicates of the current wave are updated, the bake time passed
+// and the workloads in the namespaces of the current wave that consume the duplicates are ready.
+// Rollouts of paused source secrets are halted.
+func (r *SecretReconciler) advanceRollouts(ctx context.Context, allSources []*corev1.Secret,
+	allNamespaces []*corev1.Namespace, pausedNamespaces map[string]bool, waves map[string]int,
+	allDuplicates []*corev1.Secret) (map[string]*rolloutStatus, time.Duration) {
+	local := targetCluster{Client: r.Client}
+	workloads := &workloadSnapshot{reader: r.apiReader}
+	rollouts := make(map[string]*rolloutStatus)
+	var requeueAfter time.Duration
+	for _, source := range allSources {
+		if !isStagedRollout(source) {
+			continue
+		}
+		key := client.ObjectKeyFromObject(source).String()
+		previous := currentSourceStatus(source).Rollout
+		if r.isSourceFrozen(source) {
+			if previous != nil && !previous.Completed {
+				previous.Waiting = "halted because the source secret is paused, invalid or held back"
+			}
+			rollouts[key] = previous
+			continue
+		}
+
+		// Find the waves of the namespaces that should have a duplicate, and the waves with outdated duplicates
+		hash := sourceContentHash(source)
+		var targetWaves []int
+		targetNamespaces := make(map[string]bool)
+		for _, namespace := range allNamespaces {
+			if !pausedNamespaces[namespace.Name] && r.isTargetNamespace(local, source, namespace) {
+				targetWaves = append(targetWaves, waves[namespace.Name])
+				targetNamespaces[namespace.Name] = true
+			}
+		}
+		sort.Ints(targetWaves)
+		outdated := make(map[int]bool)
+		for _, duplicate := range allDuplicates {
+			if duplicate.Annotations[duplicatorFromAnnotationKey] == key && targetNamespaces[duplicate.Namespace] &&
+				!isVersionedCopy(duplicate) &&
+				duplicate.Annotations[duplicatorContentHashAnnotationKey] != hash {
+				outdated[waves[duplicate.Namespace]] = true
+			}
+		}
+
+		rollout := previous
+		if rollout == nil || rollout.Hash != hash {
+			// The source secret changed, start a new rollout with the first wave
+			rollout = &rolloutStatus{Hash: hash, Completed: len(outdated) == 0}
+			if len(targetWaves) > 0 {
+				rollout.Wave = targetWaves[0]
+			}
+		}
+		rollouts[key] = rollout
+		if !rollout.Completed {
+			delay := r.advanceRollout(ctx, workloads, source, rollout, targetWaves, waves, targetNamespaces, outdated)
+			if delay > 0 && (requeueAfter == 0 || delay < requeueAfter) {
+				requeueAfter = delay
+			}
+		}
+	}
+	return rollouts, requeueAfter
+}
+
+// advanceRollout moves rollout to the next wave if the current wave is done,
+// and returns the delay after which the rollout must be checked again.
+func (r *SecretReconciler) advanceRollout(ctx context.Context, workloads *workloadSnapshot, source *corev1.Secret,
+	rollout *rolloutStatus, targetWaves []int, waves map[string]int, targetNamespaces map[string]bool,
+	outdated map[int]bool) time.Duration {
+	for {
+		for wave := range outdated {
+			if wave <= rollout.Wave {
+				// Updated duplicates trigger the next reconcile
+				rollout.Waiting = fmt.Sprintf("waiting for the duplicates of wave %d to be updated", rollout.Wave)
+				rollout.WaveCompletedAt = nil
+				return 0
+			}
+		}
+		now := time.Now()
+		if rollout.WaveCompletedAt == nil {
+			rollout.WaveCompletedAt = &v1.Time{Time: now}
+		}
+		bakeTime := cmp.Or(r.RolloutBakeTime, defaultRolloutBakeTime)
+		if remaining := rollout.WaveCompletedAt.Add(bakeTime).Sub(now); remaining > 0 {
+			rollout.Waiting = fmt.Sprintf("baking wave %d until %s", rollout.Wave,
+				rollout.WaveCompletedAt.Add(bakeTime).UTC().Format(time.RFC3339))
+			return remaining
+		}
+		var waveNamespaces []string
+		for namespace := range targetNamespaces {
+			if waves[namespace] == rollout.Wave {
+				waveNamespaces = append(waveNamespaces, namespace)
+			}
+		}
+		sort.Strings(waveNamespaces)
+		unready, err := unreadyConsumers(ctx, workloads, waveNamespaces, source.Name)
+		if err != nil {
+			rollout.Waiting = fmt.Sprintf("health check of wave %d failed: %v", rollout.Wave, err)
+			return rolloutCheckInterval
+		}
+		if len(unready) > 0 {
+			rollout.Waiting = fmt.Sprintf("waiting for ready workloads in wave %d: %s", rollout.Wave,
+				strings.Join(unready, ", "))
+			return rolloutCheckInterval
+		}
+
+		next := -1
+		for _, wave := range targetWaves {
+			if wave > rollout.Wave {
+				next = wave
+				break
+			}
+		}
+		if next == -1 {
+			*rollout = rolloutStatus{Hash: rollout.Hash, Wave: rollout.Wave, Completed: true}
+			log.FromContext(ctx).Info("completed staged rollout", "source", client.ObjectKeyFromObject(source).String())
+			return 0
+		}
+		*rollout = rolloutStatus{Hash: rollout.Hash, Wave: next}
+		log.FromContext(ctx).Info("staged rollout proceeds to next wave",
+			"source", client.ObjectKeyFromObject(source).String(), "wave", next)
+		r.Recorder.Eventf(source, corev1.EventTypeNormal, reasonRolloutWaveStarted,
+			"Rolling out to the namespaces of wave %d", next)
+	}
+}
+
+// workloadSnapshot lists the workloads of the cluster at most once per reconcile, so that the health checks
+// of staged rollouts don't send a request per kind, wave and namespace. The zero value lists nothing.
+type workloadSnapshot struct {
+	reader client.Reader
+	listed bool
+	err    error
+	// byNamespace contains the listed workloads by namespace
+	byNamespace map[string][]client.Object
+}
+
+// consumers returns the workloads in namespace that consume the secret.
+func (s *workloadSnapshot) consumers(ctx context.Context, namespace, secret string) ([]client.Object, error) {
+	if s.reader == nil {
+		return nil, nil
+	}
+	if !s.listed {
+		s.listed = true
+		var workloads []client.Object
+		workloads, s.err = listWorkloads(ctx, s.reader)
+		s.byNamespace = make(map[string][]client.Object)
+		for _, workload := range workloads {
+			s.byNamespace[workload.GetNamespace()] = append(s.byNamespace[workload.GetNamespace()], workload)
+		}
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return filterConsumers(s.byNamespace[namespace], secret), nil
+}
+
+// unreadyConsumers returns the workloads in namespaces that consume the secret and aren't ready.
+func unreadyConsumers(ctx context.Context, workloads *workloadSnapshot, namespaces []string,
+	secret string) ([]string, error) {
+	var unready []string
+	for _, namespace := range namespaces {
+		consumers, err := workloads.consumers(ctx, namespace, secret)
+		if err != nil {
+			return nil, err
+		}
+		for _, consumer := range consumers {
+			if !isWorkloadReady(consumer) {
+				unready = append(unready, kindOf(consumer)+" "+client.ObjectKeyFromObject(consumer).String())
+			}
+		}
+	}
+	return unready, nil
+}
+
+// isWorkloadReady returns true if all replicas of a Deployment, StatefulSet or DaemonSet
+// run the current pod template and are available.
+func isWorkloadReady(workload client.Object) bool {
+	if workload.GetGeneration() != observedGeneration(workload) {
+		return false
+	}
+	switch w := workload.(type) {
+	case *appsv1.Deployment:
+		replicas := int32(1)
+		if w.Spec.Replicas != nil {
+			replicas = *w.Spec.Replicas
+		}
+		return w.Status.UpdatedReplicas == replicas && w.Status.AvailableReplicas == replicas &&
+			w.Status.Replicas == replicas
+	case *appsv1.StatefulSet:
+		replicas := int32(1)
+		if w.Spec.Replicas != nil {
+			replicas = *w.Spec.Replicas
+		}
+		return w.Status.UpdatedReplicas == replicas && w.Status.ReadyReplicas == replicas
+	case *appsv1.DaemonSet:
+		return w.Status.UpdatedNumberScheduled == w.Status.DesiredNumberScheduled &&
+			w.Status.NumberAvailable == w.Status.DesiredNumberScheduled
+	}
+	return true
+}
+
+func observedGeneration(workload client.Object) int64 {
+	switch w := workload.(type) {
+	case *appsv1.Deployment:
+		return w.Status.ObservedGeneration
+	case *appsv1.StatefulSet:
+		return w.Status.ObservedGeneration
+	case *appsv1.DaemonSet:
+		return w.Status.ObservedGeneration
+	}
+	return workload.GetGeneration()
+}
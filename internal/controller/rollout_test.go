@@ -0,0 +1,210 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func Test_namespaceWaves(t *testing.T) {
+	namespace := func(name, wave string) *corev1.Namespace {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if wave != "" {
+			ns.Labels = map[string]string{duplicatorRolloutWaveLabelKey: wave}
+		}
+		return ns
+	}
+	got := namespaceWaves([]*corev1.Namespace{
+		namespace("canary", "0"),
+		namespace("early", "2"),
+		namespace("invalid", "first"),
+		namespace("negative", "-1"),
+		namespace("rest", ""),
+	})
+	want := map[string]int{"canary": 0, "early": 2, "invalid": 3, "negative": 3, "rest": 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, wanted %v", got, want)
+	}
+}
+
+func Test_SecretReconciler_advanceRollouts(t *testing.T) {
+	namespaces := []*corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "canary", Labels: map[string]string{duplicatorRolloutWaveLabelKey: "0"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "rest"}},
+	}
+	newSource := func(data string, paused bool, previous *rolloutStatus) *corev1.Secret {
+		source := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "secret1",
+				Namespace: "ns1",
+				Annotations: map[string]string{
+					duplicatorDuplicateAnnotationKey:     "true",
+					duplicatorStagedRolloutAnnotationKey: "true",
+				},
+			},
+			Data: map[string][]byte{"foo": []byte(data)},
+		}
+		if paused {
+			source.Annotations[duplicatorPausedAnnotationKey] = "true"
+		}
+		if previous != nil {
+			status, _ := json.Marshal(sourceStatus{Rollout: previous})
+			source.Annotations[duplicatorStatusAnnotationKey] = string(status)
+		}
+		return source
+	}
+	oldSource := newSource("old", false, nil)
+	newHash := sourceContentHash(newSource("new", false, nil))
+	duplicates := func(canaryData, restData string) []*corev1.Secret {
+		return []*corev1.Secret{
+			newDuplicateSecret(newSource(canaryData, false, nil), "canary"),
+			newDuplicateSecret(newSource(restData, false, nil), "rest"),
+		}
+	}
+	longAgo := &metav1.Time{Time: time.Now().Add(-time.Hour)}
+
+	testCases := []struct {
+		name            string
+		source          *corev1.Secret
+		duplicates      []*corev1.Secret
+		want            *rolloutStatus
+		wantRequeue     bool
+		wantEvents      []string
+		wantCompletedAt bool
+	}{
+		{
+			name:       "unchanged source",
+			source:     oldSource,
+			duplicates: duplicates("old", "old"),
+			want:       &rolloutStatus{Hash: sourceContentHash(oldSource), Wave: 0, Completed: true},
+		},
+		{
+			name:       "changed source starts with first wave",
+			source:     newSource("new", false, nil),
+			duplicates: duplicates("old", "old"),
+			want:       &rolloutStatus{Hash: newHash, Wave: 0, Waiting: "waiting for the duplicates of wave 0 to be updated"},
+		},
+		{
+			name:            "updated wave bakes",
+			source:          newSource("new", false, &rolloutStatus{Hash: newHash, Wave: 0}),
+			duplicates:      duplicates("new", "old"),
+			want:            &rolloutStatus{Hash: newHash, Wave: 0},
+			wantRequeue:     true,
+			wantCompletedAt: true,
+		},
+		{
+			name:       "baked wave proceeds to next wave",
+			source:     newSource("new", false, &rolloutStatus{Hash: newHash, Wave: 0, WaveCompletedAt: longAgo}),
+			duplicates: duplicates("new", "old"),
+			want:       &rolloutStatus{Hash: newHash, Wave: 1, Waiting: "waiting for the duplicates of wave 1 to be updated"},
+			wantEvents: []string{"Normal RolloutWaveStarted Rolling out to the namespaces of wave 1"},
+		},
+		{
+			name:       "baked last wave completes",
+			source:     newSource("new", false, &rolloutStatus{Hash: newHash, Wave: 1, WaveCompletedAt: longAgo}),
+			duplicates: duplicates("new", "new"),
+			want:       &rolloutStatus{Hash: newHash, Wave: 1, Completed: true},
+		},
+		{
+			name:       "paused source halts rollout",
+			source:     newSource("new", true, &rolloutStatus{Hash: newHash, Wave: 0, WaveCompletedAt: longAgo}),
+			duplicates: duplicates("new", "old"),
+			want: &rolloutStatus{Hash: newHash, Wave: 0, WaveCompletedAt: longAgo,
+				Waiting: "halted because the source secret is paused, invalid or held back"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			r := &SecretReconciler{Recorder: recorder, RolloutBakeTime: time.Minute}
+			rollouts, requeueAfter := r.advanceRollouts(context.Background(), []*corev1.Secret{tc.source}, namespaces,
+				nil, namespaceWaves(namespaces), tc.duplicates)
+
+			got := rollouts["ns1/secret1"]
+			if got == nil {
+				t.Fatalf("got no rollout")
+			}
+			if (got.WaveCompletedAt != nil) != (tc.wantCompletedAt || tc.want.WaveCompletedAt != nil) {
+				t.Errorf("got wave completed at %v, wanted set %v", got.WaveCompletedAt, tc.wantCompletedAt)
+			}
+			if tc.wantCompletedAt {
+				got.WaveCompletedAt, got.Waiting = nil, ""
+			}
+			if got.WaveCompletedAt != nil && tc.want.WaveCompletedAt != nil &&
+				got.WaveCompletedAt.Unix() == tc.want.WaveCompletedAt.Unix() {
+				got.WaveCompletedAt = tc.want.WaveCompletedAt
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got rollout %+v, wanted %+v", got, tc.want)
+			}
+			if (requeueAfter > 0) != tc.wantRequeue {
+				t.Errorf("got requeue after %v, wanted requeue %v", requeueAfter, tc.wantRequeue)
+			}
+			close(recorder.Events)
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			if !reflect.DeepEqual(events, tc.wantEvents) {
+				t.Errorf("got events %v, wanted %v", events, tc.wantEvents)
+			}
+		})
+	}
+}
+
+func Test_unreadyConsumers(t *testing.T) {
+	consumer := func(name, namespace, secret string, ready bool) *appsv1.Deployment {
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+				VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: secret}},
+			}}}}},
+		}
+		if ready {
+			deployment.Status = appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+		}
+		return deployment
+	}
+	lists := 0
+	c := fake.NewClientBuilder().
+		WithObjects(
+			consumer("ready", "ns1", "secret1", true),
+			consumer("unready", "ns2", "secret1", false),
+			consumer("other-secret", "ns2", "secret2", false),
+			consumer("other-namespace", "ns3", "secret1", false),
+		).
+		WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				lists++
+				return c.List(ctx, list, opts...)
+			},
+		}).
+		Build()
+	workloads := &workloadSnapshot{reader: c}
+
+	for i := 0; i < 2; i++ {
+		got, err := unreadyConsumers(context.Background(), workloads, []string{"ns1", "ns2"}, "secret1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := []string{"Deployment ns2/unready"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got unready consumers %v, wanted %v", got, want)
+		}
+	}
+	// Each kind is listed once for all namespaces and health checks
+	if lists != 3 {
+		t.Errorf("got %d list requests, wanted 3", lists)
+	}
+}
@@ -17,31 +17,168 @@ limitations under the License.
 package controller
 
 import (
+	"cmp"
 	"context"
-	"reflect"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// Mode decides which namespaces receive duplicates of a source secret.
+type Mode string
+
+const (
+	// ModePush duplicates source secrets into all namespaces.
+	ModePush Mode = "push"
+	// ModePull duplicates source secrets only into namespaces that request them with the pull annotation.
+	// The source secret must allow the namespace to pull it.
+	ModePull Mode = "pull"
+)
+
+// SourceRecreatePolicy decides what happens to the duplicates of a source secret
+// that was deleted and recreated with the same name.
+type SourceRecreatePolicy string
+
+const (
+	// SourceRecreatePolicyUpdate updates the duplicates like for a changed source secret.
+	SourceRecreatePolicyUpdate SourceRecreatePolicy = "update"
+	// SourceRecreatePolicyRecreate deletes the duplicates and creates them again.
+	SourceRecreatePolicyRecreate SourceRecreatePolicy = "recreate"
+	// SourceRecreatePolicyReject leaves the duplicates untouched.
+	SourceRecreatePolicyReject SourceRecreatePolicy = "reject"
 )
 
 // SecretReconciler reconciles a Secret object
 type SecretReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// Mode decides which namespaces receive duplicates of a source secret.
+	// Defaults to ModePush if empty.
+	Mode Mode
+	// SourceRecreatePolicy decides what happens to the duplicates of a recreated source secret.
+	// Defaults to SourceRecreatePolicyUpdate if empty.
+	SourceRecreatePolicy SourceRecreatePolicy
+	// KeepDuplicatesInIgnoredNamespaces prevents the deletion of existing duplicates in namespaces
+	// that opted out with the ignore annotation or label.
+	KeepDuplicatesInIgnoredNamespaces bool
+	// SourceNamespaces lists the namespaces that are allowed to host source secrets.
+	// Source secrets in namespaces that are neither listed nor match SourceNamespaceSelector are rejected.
+	// All namespaces are allowed if SourceNamespaces is empty and SourceNamespaceSelector is nil.
+	SourceNamespaces []string
+	// SourceNamespaceSelector selects the namespaces that are allowed to host source secrets.
+	SourceNamespaceSelector labels.Selector
+	// DryRun makes the reconciler only report the creates, updates and deletes it would perform.
+	// The writes are still sent to the API server with server-side dry-run to validate them.
+	DryRun bool
+	// ClusterName identifies this cluster in the source annotation of duplicates in remote clusters.
+	// It must be set to sync duplicates into remote clusters.
+	ClusterName string
+	// ControllerNamespace is the namespace of the controller that contains the kubeconfig secrets of remote clusters.
+	ControllerNamespace string
+	// SourceClusters lists the kubeconfig secrets of remote clusters whose source secrets are duplicated
+	// into the local cluster.
+	SourceClusters []string
+	// ResyncPeriod is the interval of periodic full reconciles in addition to the reconciles triggered by watch events.
+	// Periodic full reconciles are disabled if ResyncPeriod is zero.
+	ResyncPeriod time.Duration
+	// LivenessResyncPeriods is the number of resync periods without a completed reconcile
+	// after which the leader reports not live. Disabled if zero or if ResyncPeriod is zero.
+	LivenessResyncPeriods int
+	// StuckReconcileTimeout is the duration without a completed write after which a running reconcile
+	// is considered stuck and the controller reports not live. Disabled if zero.
+	StuckReconcileTimeout time.Duration
+	// MaxConcurrentWrites is the maximum number of concurrent reads and writes of duplicates within a reconcile.
+	// Defaults to 1.
+	MaxConcurrentWrites int
+	// TargetBackoffBase is the delay before the first retry of a failed write of a duplicate.
+	// The delay doubles with each failure up to TargetBackoffMax. Defaults to 1s and 5m.
+	TargetBackoffBase time.Duration
+	TargetBackoffMax  time.Duration
+	// WorkloadRestartQPS and WorkloadRestartBurst rate limit the restarts of workloads that consume duplicates
+	// of source secrets with the restart-workloads annotation. Default to 1 and 10.
+	WorkloadRestartQPS   float32
+	WorkloadRestartBurst int
+	// RolloutBakeTime is the time to wait after a wave of a staged rollout is updated before the next wave.
+	// Defaults to 10m.
+	RolloutBakeTime time.Duration
+	// RevisionHistoryLimit is the number of revisions of each source secret that are kept in its history secret
+	// in ControllerNamespace, so duplicates can be pinned to a previous revision. Disabled if zero.
+	RevisionHistoryLimit int
+	// VersionRetention is the time after which superseded versioned copies of source secrets with the
+	// versioned-names annotation are deleted. Defaults to 24h.
+	VersionRetention time.Duration
+	// OwnershipKeySecret is the name of the secret in ControllerNamespace that contains the key
+	// to sign duplicates with. It is created if it doesn't exist. Reconciles fail without syncing any duplicate
+	// while it can't be loaded or created.
+	OwnershipKeySecret string
+	// AdoptUnmarkedDuplicates makes the controller take over duplicates without ownership marker,
+	// e.g. to upgrade from a version without ownership markers.
+	AdoptUnmarkedDuplicates bool
+	// TargetMaxRetries is the number of failed writes of a duplicate after which the controller stops retrying
+	// until the source secret changes. Unlimited if zero.
+	TargetMaxRetries int
+	// HoldInvalidCertificates keeps the duplicates of TLS source secrets at their current content
+	// while the certificate of the source secret doesn't parse, doesn't match its key or is expired.
+	HoldInvalidCertificates bool
+
+	clusters            *clusterRegistry
+	fullReconcileEvents chan event.GenericEvent
+	backoff             targetBackoff
+	conflicts           conflictTracker
+	recreations         recreationTracker
+	versionGC           versionGC
+	certificates        certificateCache
+	certificateExpiries certificateExpiries
+	restarter           *workloadRestarter
+	// apiReader reads workloads without caching them
+	apiReader client.Reader
+	// ownership is the ownership key of the current reconcile
+	ownership atomic.Pointer[ownershipKey]
+	// leaderSince is the unix time in nanoseconds when periodic full reconciles started
+	leaderSince atomic.Int64
+	// reconcileStartedAt is the unix time in nanoseconds when the running reconcile started, zero if none is running
+	reconcileStartedAt atomic.Int64
+	// lastProgressAt is the unix time in nanoseconds when a reconcile last completed a write
+	lastProgressAt atomic.Int64
+	// lastReconcileCompletedAt is the unix time in nanoseconds when the last reconcile completed
+	lastReconcileCompletedAt atomic.Int64
+	// lastFullReconcileAt is the unix time in nanoseconds when the last reconcile went through all clusters
+	// and source secrets, even if some of them failed
+	lastFullReconcileAt atomic.Int64
+	// failedReconciles is the number of consecutive reconciles that failed with errors that retrying can fix
+	failedReconciles int
+	// pendingRestartsResumed is true after the first reconcile requested the pending restarts of workloads
+	pendingRestartsResumed bool
 }
 
 //+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=secrets/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=core,resources=secrets/finalizers,verbs=update
 //+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=list;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -50,6 +187,9 @@ type SecretReconciler struct {
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.16.3/pkg/reconcile
 func (r *SecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx).V(2)
+	r.reconcileStarted()
+	defer r.reconcileCompleted()
+	started := time.Now()
 
 	// Retrieve all secrets
 	allSecrets := &corev1.SecretList{}
@@ -64,102 +204,624 @@ func (r *SecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		return ctrl.Result{}, err
 	}
 
+	// Only duplicates with a valid ownership marker are updated and deleted
+	ownership, err := r.loadOwnershipKey(ctx, allSecrets)
+	if err != nil {
+		err = aggregateErrors([]error{err})
+		logger.V(1).Error(err, "reconcile failed")
+		return ctrl.Result{RequeueAfter: r.retryAfter(err)}, nil
+	}
+	r.ownership.Store(ownership)
+	r.recreations.reset()
+
 	// Find existing source secrets
 	allSourceSecrets := findAllSourceSecrets(allSecrets)
 	logger.Info("found source secrets", "count", len(allSourceSecrets))
+	// Reject source secrets in namespaces that aren't allowed to host source secrets
+	allSourceSecrets, rejectedSourceSecrets := r.partitionSourcesByNamespace(allSourceSecrets, allNamespaces.Items)
+	logger.Info("found rejected source secrets", "count", len(rejectedSourceSecrets))
+	rejectedSourcesGauge.Set(float64(len(rejectedSourceSecrets)))
 	// Find existing duplicates
-	allDuplicateSecrets := findAllDuplicateSecrets(allSecrets)
+	allDuplicateSecrets := r.ownedDuplicates(ctx, ownership, findAllDuplicateSecrets(allSecrets))
 	logger.Info("found duplicate secrets", "count", len(allDuplicateSecrets))
+	r.resumePendingRestarts(ctx, ownership, allSecrets)
+	// Report the expiry of the certificates of TLS source secrets and their duplicates
+	r.observeCertificateExpiry(allSourceSecrets, allDuplicateSecrets)
+	// Record the content of source secrets in their revision history,
+	// and sync pinned source secrets with the content of their pinned revision
+	var errs []error
+	histories := findHistorySecrets(allSecrets, r.ControllerNamespace)
+	err = r.reconcileHistories(ctx, allSourceSecrets, rejectedSourceSecrets, histories)
+	errs = append(errs, flattenErrors(err)...)
+	allSourceSecrets, pinErrors := r.resolvePins(ctx, allSourceSecrets, histories)
 	// Filter out namespaces in terminating state because resources in those namespaces cannot be updated
 	nonTerminatingNamespaces := findNonTerminatingNamespaces(allNamespaces.Items)
 	logger.Info("found non-terminating namespaces", "count", len(nonTerminatingNamespaces))
+	// Find paused namespaces, the controller must not touch secrets in those namespaces
+	pausedNamespaces := findPausedNamespaces(allNamespaces.Items)
+	logger.Info("found paused namespaces", "count", len(pausedNamespaces))
+	pausedNamespacesGauge.Set(float64(len(pausedNamespaces)))
+	// Count namespaces that opted out of receiving duplicates
+	ignoredNamespaces := findIgnoredNamespaces(allNamespaces.Items)
+	logger.Info("found ignored namespaces", "count", len(ignoredNamespaces))
+	ignoredNamespacesGauge.Set(float64(len(ignoredNamespaces)))
+	pausedSourcesGauge.Set(float64(countPausedSources(allSourceSecrets)))
 
-	// Ensure duplicates exist in all namespaces for all source secrets
-	var retryableError error
-	logger.Info("Reconciling sources by creating missing duplicates")
-	err = r.reconcileSources(ctx, nonTerminatingNamespaces, allSourceSecrets)
+	// Proceed with staged rollouts of source secrets
+	waves := namespaceWaves(nonTerminatingNamespaces)
+	rollouts, rolloutRequeueAfter := r.advanceRollouts(ctx, allSourceSecrets, nonTerminatingNamespaces,
+		pausedNamespaces, waves, allDuplicateSecrets)
+
+	// Sync duplicates in the local cluster
+	local := targetCluster{Client: r.Client, rollouts: rollouts, waves: waves}
+	err = r.reconcileCluster(ctx, local, allSecrets, nonTerminatingNamespaces, pausedNamespaces, allDuplicateSecrets,
+		allSourceSecrets)
+	errs = append(errs, flattenErrors(err)...)
+
+	// Sync duplicates in remote clusters
+	logger.Info("Reconciling remote clusters")
+	clusterErrors := r.reconcileRemoteClusters(ctx, allSecrets, allSourceSecrets)
+	errs = append(errs, clusterErrorList(clusterErrors)...)
+
+	// Sync duplicates of source secrets in remote source clusters
+	logger.Info("Reconciling source clusters")
+	sourceClusterErrors := r.reconcileSourceClusters(ctx, allSecrets, nonTerminatingNamespaces, pausedNamespaces)
+	errs = append(errs, clusterErrorList(sourceClusterErrors)...)
+
+	// Forget failed targets that don't exist anymore
+	r.backoff.prune(started)
+	r.conflicts.prune()
+	r.certificates.prune()
+
+	// Report state of source secrets in their status annotation
+	logger.Info("Updating status of source secrets")
+	err = r.updateSourceStatuses(ctx, allSourceSecrets, nonTerminatingNamespaces, pausedNamespaces, clusterErrors,
+		rollouts, pinErrors)
+	errs = append(errs, flattenErrors(err)...)
+	err = r.reportRejectedSources(ctx, rejectedSourceSecrets)
+	errs = append(errs, flattenErrors(err)...)
+
+	// Errors of single duplicates, source secrets and remote clusters are reported in the status annotations
+	// and metrics, and don't make the leader unready
+	r.lastFullReconcileAt.Store(time.Now().UnixNano())
+
+	// Failing duplicates are reported by their own metric and don't fail the reconcile
+	err = aggregateErrors(errs)
 	if err != nil {
-		retryableError = err
+		logger.V(1).Error(err, "reconcile failed", "errors", len(errs))
+	} else {
+		observeSuccessfulReconcile()
 	}
+	// Failed targets are retried with their own backoff. The errors aren't returned, because
+	// controller-runtime ignores RequeueAfter if Reconcile returns an error, which would drop the retries
+	// of failed targets, the next waves of staged rollouts and the expiry of versioned copies.
+	requeueAfter := r.backoff.nextRetry(time.Now())
+	for _, after := range []time.Duration{rolloutRequeueAfter, r.versionGC.nextExpiry(time.Now()), r.retryAfter(err)} {
+		if after > 0 && (requeueAfter == 0 || after < requeueAfter) {
+			requeueAfter = after
+		}
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// retryAfter returns the delay before retrying a reconcile that failed with err,
+// or zero if err is nil or terminal. The delay starts at TargetBackoffBase and doubles
+// with each consecutive failed reconcile up to TargetBackoffMax.
+func (r *SecretReconciler) retryAfter(err error) time.Duration {
+	if err == nil {
+		r.failedReconciles = 0
+		return 0
+	}
+	if errors.Is(err, reconcile.TerminalError(nil)) {
+		return 0
+	}
+	r.failedReconciles++
+	maxDelay := cmp.Or(r.TargetBackoffMax, defaultTargetBackoffMax)
+	if r.failedReconciles >= 32 {
+		return maxDelay
+	}
+	return min(cmp.Or(r.TargetBackoffBase, defaultTargetBackoffBase)<<(r.failedReconciles-1), maxDelay)
+}
+
+// reconcileCluster creates missing duplicates, removes orphaned duplicates and updates out of sync duplicates
+// in the target cluster. allSecrets contains all secrets in the target cluster.
+func (r *SecretReconciler) reconcileCluster(ctx context.Context, target targetCluster, allSecrets *corev1.SecretList,
+	allNamespaces []*corev1.Namespace, pausedNamespaces map[string]bool, allDuplicates, allSources []*corev1.Secret) error {
+	logger := log.FromContext(ctx).V(2).WithValues("cluster", target.name)
+	allDuplicates, allVersions := partitionVersionedCopies(allDuplicates)
+
+	// Ensure duplicates exist in all namespaces for all source secrets
+	existingSecrets := indexSecrets(allSecrets)
+	logger.Info("Reconciling sources by creating missing duplicates")
+	sourcesErr := r.reconcileSources(ctx, target, existingSecrets, allNamespaces, pausedNamespaces, allSources)
 
 	// Remove orphaned duplicates and update out of sync duplicates
 	logger.Info("Reconciling duplicates by removing orphaned duplicates and updating out of sync duplicates")
-	err = r.reconcileDuplicates(ctx, allDuplicateSecrets, allSourceSecrets)
-	if err != nil {
-		retryableError = err
-	}
+	duplicatesErr := r.reconcileDuplicates(ctx, target, allDuplicates, allSources, allNamespaces, pausedNamespaces)
 
-	if retryableError != nil {
-		logger.V(1).Error(retryableError, "retrying reconcile with exponential backoff")
-	}
-	return ctrl.Result{}, retryableError
+	// Create versioned copies of source secrets with the versioned-names annotation
+	logger.Info("Reconciling versioned copies")
+	versionsErr := r.reconcileVersions(ctx, target, existingSecrets, allVersions, allSources, allNamespaces,
+		pausedNamespaces)
+	return errors.Join(sourcesErr, duplicatesErr, versionsErr)
 }
 
-func (r *SecretReconciler) reconcileSources(ctx context.Context, allNamespaces []*corev1.Namespace, allSources []*corev1.Secret) error {
-	var retryableError error
+// reconcileSources creates missing duplicates. Whether a duplicate is missing is decided with existingSecrets,
+// the index of all secrets in the target cluster, instead of reading every duplicate from the API server.
+func (r *SecretReconciler) reconcileSources(ctx context.Context, target targetCluster,
+	existingSecrets map[client.ObjectKey]*corev1.Secret, allNamespaces []*corev1.Namespace,
+	pausedNamespaces map[string]bool, allSources []*corev1.Secret) error {
+	ownership := r.ownership.Load()
+	pool := newWorkerPool(r.MaxConcurrentWrites)
 	for _, sourceSecret := range allSources {
+		if r.isSourceFrozen(sourceSecret) || !isSourceSyncedTo(sourceSecret, target) {
+			continue
+		}
 		// Create missing duplicates
 		for _, namespace := range allNamespaces {
+			if pausedNamespaces[namespace.Name] || !r.isTargetNamespace(target, sourceSecret, namespace) {
+				continue
+			}
 			duplicateObjectKey := client.ObjectKey{
 				Namespace: namespace.Name,
 				Name:      sourceSecret.Name,
 			}
-			err := r.Get(ctx, duplicateObjectKey, &corev1.Secret{})
-			if err != nil {
-				if errors.IsNotFound(err) {
-					duplicate := newDuplicateSecret(sourceSecret, namespace.Name)
-					err = r.Create(ctx, duplicate)
-					if err != nil && !errors.IsAlreadyExists(err) {
-						retryableError = err
-					}
-				} else {
-					retryableError = err
+			key := targetKey{cluster: target.name, source: sourcePullKey(target, sourceSecret), namespace: namespace.Name}
+			if existing, ok := existingSecrets[duplicateObjectKey]; ok {
+				// The existing secret is never overwritten if it isn't an owned duplicate of the source secret
+				if existing.Annotations[duplicatorFromAnnotationKey] != r.sourceReference(target, sourceSecret) ||
+					(ownership != nil && !r.isOwned(ownership, existing)) {
+					r.reportConflict(ctx, target, key, sourceSecret, existing)
 				}
+				continue
 			}
+			r.writeTarget(ctx, pool, key, sourceContentHash(sourceSecret), func() error {
+				duplicate := r.newTargetDuplicateSecret(target, sourceSecret, namespace.Name)
+				err := r.createDuplicate(ctx, target, sourceSecret, duplicate)
+				if k8sErrors.IsAlreadyExists(err) {
+					// The list of secrets was stale. The existing secret is never overwritten,
+					// and the next reconcile decides with an up-to-date list whether it is an out of sync duplicate.
+					log.FromContext(ctx).V(1).Info("duplicate already exists", "cluster", target.name,
+						"duplicate", duplicateObjectKey.String())
+					return nil
+				}
+				if err != nil {
+					return fmt.Errorf("create duplicate %s of source %s: %w",
+						duplicateObjectKey, client.ObjectKeyFromObject(sourceSecret), err)
+				}
+				return nil
+			})
 		}
 	}
-	return retryableError
+	return pool.Wait()
 }
 
-func (r *SecretReconciler) reconcileDuplicates(ctx context.Context, allDuplicates, allSources []*corev1.Secret) error {
-	// Build lookup map for all source secrets
+func (r *SecretReconciler) reconcileDuplicates(ctx context.Context, target targetCluster,
+	allDuplicates, allSources []*corev1.Secret, allNamespaces []*corev1.Namespace, pausedNamespaces map[string]bool) error {
+	// Build lookup map for all source secrets by the source annotation of their duplicates in target
 	sourceSecretsMap := make(map[string]*corev1.Secret)
 	for _, source := range allSources {
 		s := source
-		key := client.ObjectKeyFromObject(source).String()
+		key := r.sourceReference(target, source)
 		sourceSecretsMap[key] = s
 	}
 
-	var retryableError error
+	ownership := r.ownership.Load()
+	// recreatedSources contains the source secrets that were recreated with the UID of the previous source secret
+	recreatedSources := make(map[*corev1.Secret]string)
+
+	// Build lookup map for all non-terminating namespaces
+	namespacesMap := make(map[string]*corev1.Namespace)
+	for _, namespace := range allNamespaces {
+		namespacesMap[namespace.Name] = namespace
+	}
 
+	pool := newWorkerPool(r.MaxConcurrentWrites)
 	for _, duplicate := range allDuplicates {
+		if pausedNamespaces[duplicate.Namespace] {
+			continue
+		}
+		namespace, ok := namespacesMap[duplicate.Namespace]
+		if !ok {
+			// Duplicates in terminating namespaces are deleted together with the namespace
+			continue
+		}
+		if isNamespaceIgnored(namespace) && r.KeepDuplicatesInIgnoredNamespaces {
+			continue
+		}
 		// annotation must exist because isDuplicateSecret() is used to create the list of duplicates,
 		// and it verifies the annotation exists.
 		fromAnnotation := duplicate.Annotations[duplicatorFromAnnotationKey]
 		sourceSecret, ok := sourceSecretsMap[fromAnnotation]
-		if !ok {
-			// Delete duplicate if no matching source secret exists
-			err := r.Delete(ctx, duplicate)
-			if err != nil && !errors.IsNotFound(err) {
-				retryableError = err
+		if ok && r.isSourceFrozen(sourceSecret) {
+			continue
+		}
+		if !ok || !isSourceSyncedTo(sourceSecret, target) || !r.isTargetNamespace(target, sourceSecret, namespace) {
+			// Delete duplicate if no matching source secret exists or the cluster or namespace
+			// shouldn't receive a duplicate of the source secret anymore
+			key := targetKey{
+				cluster:   target.name,
+				source:    strings.TrimPrefix(fromAnnotation, r.ClusterName+"/"),
+				namespace: duplicate.Namespace,
 			}
-		} else {
-			// Update duplicate when source and duplicate are out of sync
-			if !reflect.DeepEqual(duplicate.Data, sourceSecret.Data) {
-				updated := newDuplicateSecret(sourceSecret, duplicate.Namespace)
-				err := r.Update(ctx, updated)
+			r.writeTarget(ctx, pool, key, "", func() error {
+				err := r.deleteDuplicate(ctx, target, duplicate)
+				if err != nil && !k8sErrors.IsNotFound(err) {
+					return fmt.Errorf("delete duplicate %s: %w", client.ObjectKeyFromObject(duplicate), err)
+				}
+				return nil
+			})
+		} else if !isInRolloutWave(target.rollouts[fromAnnotation], target.waves, duplicate.Namespace) {
+			// Staged rollouts update the duplicates in later waves after the earlier waves are done
+			continue
+		} else if previousUID := duplicate.Annotations[duplicatorSourceUIDAnnotationKey]; isSourceRecreated(sourceSecret,
+			duplicate) && r.recreatePolicy(sourceSecret, previousUID) != SourceRecreatePolicyUpdate {
+			recreatedSources[sourceSecret] = previousUID
+			if r.recreatePolicy(sourceSecret, previousUID) != SourceRecreatePolicyRecreate {
+				continue
+			}
+			key := targetKey{
+				cluster:   target.name,
+				source:    sourcePullKey(target, sourceSecret),
+				namespace: duplicate.Namespace,
+			}
+			r.writeTarget(ctx, pool, key, sourceContentHash(sourceSecret), func() error {
+				recreated := r.newTargetDuplicateSecret(target, sourceSecret, duplicate.Namespace)
+				markRestartPending(target, sourceSecret, duplicate, recreated)
+				err := r.recreateDuplicate(ctx, target, sourceSecret, duplicate, recreated)
 				if err != nil {
-					retryableError = err
+					return fmt.Errorf("recreate duplicate %s of recreated source %s: %w",
+						client.ObjectKeyFromObject(duplicate), client.ObjectKeyFromObject(sourceSecret), err)
 				}
+				r.restartConsumers(ctx, recreated)
+				return nil
+			})
+		} else {
+			if isSourceRecreated(sourceSecret, duplicate) {
+				recreatedSources[sourceSecret] = duplicate.Annotations[duplicatorSourceUIDAnnotationKey]
+			}
+			// Update duplicate when source and duplicate are out of sync.
+			// The content hash annotation changes with the source secret, the hash of the content of the duplicate
+			// changes with manual edits. Unmarked duplicates that were adopted are signed as well.
+			wantHash := sourceContentHash(sourceSecret)
+			if duplicate.Annotations[duplicatorContentHashAnnotationKey] != wantHash ||
+				duplicateContentHash(duplicate) != wantHash ||
+				duplicate.Annotations[duplicatorSourceUIDAnnotationKey] != string(sourceSecret.UID) ||
+				isImmutable(duplicate) != isImmutableDuplicate(sourceSecret) ||
+				(ownership != nil && !ownership.owns(duplicate)) {
+				key := targetKey{
+					cluster:   target.name,
+					source:    sourcePullKey(target, sourceSecret),
+					namespace: duplicate.Namespace,
+				}
+				r.writeTarget(ctx, pool, key, sourceContentHash(sourceSecret), func() error {
+					updated := r.newTargetDuplicateSecret(target, sourceSecret, duplicate.Namespace)
+					markRestartPending(target, sourceSecret, duplicate, updated)
+					var err error
+					if isImmutable(duplicate) || duplicate.Type != sourceSecret.Type {
+						// Immutable secrets can't be updated, and the type of a secret is immutable
+						err = r.recreateDuplicate(ctx, target, sourceSecret, duplicate, updated)
+					} else {
+						err = r.updateDuplicate(ctx, target, sourceSecret, updated)
+					}
+					if err != nil {
+						return fmt.Errorf("update duplicate %s of source %s: %w",
+							client.ObjectKeyFromObject(duplicate), client.ObjectKeyFromObject(sourceSecret), err)
+					}
+					r.restartConsumers(ctx, updated)
+					return nil
+				})
 			}
 		}
 	}
+	for source, previousUID := range recreatedSources {
+		r.reportRecreatedSource(ctx, target, source, previousUID)
+	}
+	return pool.Wait()
+}
+
+// markRestartPending records in the restart-pending annotation of updated that the workloads consuming duplicate
+// must restart after it is updated to updated, if the data of duplicate changes and source opted in with the
+// restart-workloads annotation. A restart that is still pending for duplicate is kept.
+// Workloads are only restarted in the local cluster.
+func markRestartPending(target targetCluster, source, duplicate, updated *corev1.Secret) {
+	if target.isRemote() {
+		return
+	}
+	if hash, ok := duplicate.Annotations[duplicatorRestartPendingAnnotationKey]; ok {
+		updated.Annotations[duplicatorRestartPendingAnnotationKey] = hash
+	}
+	if source.Annotations[duplicatorRestartWorkloadsAnnotationKey] == "true" &&
+		contentHash(duplicate.Data, duplicate.Type) != contentHash(source.Data, source.Type) {
+		updated.Annotations[duplicatorRestartPendingAnnotationKey] = sourceContentHash(source)
+	}
+}
+
+// restartConsumers requests a restart of the workloads that consume duplicate if it has a pending restart.
+func (r *SecretReconciler) restartConsumers(ctx context.Context, duplicate *corev1.Secret) {
+	if _, ok := duplicate.Annotations[duplicatorRestartPendingAnnotationKey]; !ok {
+		return
+	}
+	if r.DryRun {
+		log.FromContext(ctx).Info("dry-run: would restart workloads consuming duplicate",
+			"duplicate", client.ObjectKeyFromObject(duplicate).String())
+		return
+	}
+	r.restarter.enqueue(restartRequest{
+		namespace: duplicate.Namespace,
+		secret:    duplicate.Name,
+		source:    duplicate.Annotations[duplicatorFromAnnotationKey],
+	})
+}
+
+// resumePendingRestarts requests the restarts that are still pending for owned duplicates, e.g. because the previous
+// leader stopped before it restarted all consumers. Later restarts are requested when duplicates are updated.
+func (r *SecretReconciler) resumePendingRestarts(ctx context.Context, ownership *ownershipKey,
+	allSecrets *corev1.SecretList) {
+	if r.pendingRestartsResumed {
+		return
+	}
+	r.pendingRestartsResumed = true
+	for i := range allSecrets.Items {
+		duplicate := &allSecrets.Items[i]
+		if _, ok := duplicate.Annotations[duplicatorFromAnnotationKey]; ok && r.isOwned(ownership, duplicate) {
+			r.restartConsumers(ctx, duplicate)
+		}
+	}
+}
+
+// reportRecreatedSource reports that source was deleted and recreated since its duplicates in target were synced.
+// Local source secrets report it in their status annotation, and the event is only recorded when it changes.
+func (r *SecretReconciler) reportRecreatedSource(ctx context.Context, target targetCluster, source *corev1.Secret,
+	previousUID string) {
+	policy := r.recreatePolicy(source, previousUID)
+	logger := log.FromContext(ctx).WithValues("cluster", target.name, "source", sourcePullKey(target, source),
+		"uid", source.UID, "previousUID", previousUID, "policy", policy)
+	// Events can't be attached to source secrets in remote source clusters
+	if target.sourceCluster != "" {
+		logger.Info("source secret was recreated")
+		return
+	}
+	r.recreations.add(client.ObjectKeyFromObject(source).String(), previousUID)
+	if currentSourceStatus(source).RecreatedFrom == previousUID {
+		return
+	}
+	logger.Info("source secret was recreated")
+	action := map[SourceRecreatePolicy]string{
+		SourceRecreatePolicyUpdate:   "updated",
+		SourceRecreatePolicyRecreate: "deleted and created again",
+		SourceRecreatePolicyReject:   "left untouched",
+	}[policy]
+	message := fmt.Sprintf("Source secret was recreated, previous UID %s. Duplicates are %s", previousUID, action)
+	if target.isRemote() {
+		message += " in cluster " + target.name
+	}
+	r.Recorder.Event(source, corev1.EventTypeWarning, reasonSourceRecreated, message)
+}
+
+// reportConflict reports that the duplicate of source identified by key can't be created
+// because the existing secret with its name isn't a duplicate of source.
+// Each conflict is only reported once with an event, and in the status annotation of local source secrets.
+func (r *SecretReconciler) reportConflict(ctx context.Context, target targetCluster, key targetKey,
+	source, existing *corev1.Secret) {
+	message := conflictMessage(existing, r.sourceReference(target, source))
+	if !r.conflicts.add(key, message) {
+		return
+	}
+	log.FromContext(ctx).Info("duplicate is blocked by an existing secret", "cluster", target.name,
+		"source", key.source, "namespace", key.namespace, "version", key.version, "reason", message)
+	switch {
+	case target.sourceCluster != "":
+		// Events can't be attached to source secrets in remote source clusters, so the blocking secret gets the event
+		r.Recorder.Eventf(existing, corev1.EventTypeWarning, reasonDuplicateConflict,
+			"Secret blocks the duplicate of source secret %s", key.source)
+	case target.isRemote():
+		r.Recorder.Eventf(source, corev1.EventTypeWarning, reasonDuplicateConflict,
+			"Duplicate in cluster %s can't be created: %s", target.name, message)
+	default:
+		r.Recorder.Eventf(source, corev1.EventTypeWarning, reasonDuplicateConflict,
+			"Duplicate can't be created: %s", message)
+	}
+}
+
+// writeTarget runs write in pool unless the target identified by key is backing off after failed writes.
+// Failures of write are tracked per target with targetBackoff instead of failing the reconcile.
+// sourceVersion identifies the content of the source secret, a failed target is retried immediately
+// when the source secret changes.
+func (r *SecretReconciler) writeTarget(ctx context.Context, pool *workerPool, key targetKey, sourceVersion string,
+	write func() error) {
+	if !r.backoff.ready(key, sourceVersion, time.Now()) {
+		return
+	}
+	pool.Go(func() error {
+		err := write()
+		r.observeProgress()
+		if err == nil {
+			r.backoff.succeeded(key)
+			return nil
+		}
+		base := cmp.Or(r.TargetBackoffBase, defaultTargetBackoffBase)
+		maxDelay := cmp.Or(r.TargetBackoffMax, defaultTargetBackoffMax)
+		r.backoff.failed(key, sourceVersion, err, time.Now(), base, maxDelay, r.TargetMaxRetries)
+		log.FromContext(ctx).Error(err, "failed to write duplicate, retrying with backoff",
+			"cluster", key.cluster, "source", key.source, "namespace", key.namespace, "version", key.version)
+		return nil
+	})
+}
+
+// partitionSourcesByNamespace splits allSources into the source secrets in namespaces that are allowed
+// to host source secrets and the rejected source secrets in all other namespaces.
+func (r *SecretReconciler) partitionSourcesByNamespace(allSources []*corev1.Secret,
+	allNamespaces []corev1.Namespace) ([]*corev1.Secret, []*corev1.Secret) {
+	if len(r.SourceNamespaces) == 0 && r.SourceNamespaceSelector == nil {
+		return allSources, nil
+	}
+	namespaceLabels := make(map[string]labels.Set)
+	for _, namespace := range allNamespaces {
+		namespaceLabels[namespace.Name] = namespace.Labels
+	}
+	allowedSources := make([]*corev1.Secret, 0, len(allSources))
+	rejectedSources := make([]*corev1.Secret, 0)
+	for _, source := range allSources {
+		allowed := slices.Contains(r.SourceNamespaces, source.Namespace)
+		if !allowed && r.SourceNamespaceSelector != nil {
+			nsLabels, ok := namespaceLabels[source.Namespace]
+			allowed = ok && r.SourceNamespaceSelector.Matches(nsLabels)
+		}
+		if allowed {
+			allowedSources = append(allowedSources, source)
+		} else {
+			rejectedSources = append(rejectedSources, source)
+		}
+	}
+	return allowedSources, rejectedSources
+}
+
+// isTargetNamespace returns true if namespace in target should contain a duplicate of source.
+func (r *SecretReconciler) isTargetNamespace(target targetCluster, source *corev1.Secret,
+	namespace *corev1.Namespace) bool {
+	if (target.isLocal() && namespace.Name == source.Namespace) || isNamespaceIgnored(namespace) {
+		return false
+	}
+	if r.Mode != ModePull {
+		return true
+	}
+	return isSourcePulledBy(sourcePullKey(target, source), namespace) && isSourcePullAllowed(source, namespace)
+}
 
-	return retryableError
+// sourceReference returns the value of the source annotation of the duplicates of source in target.
+// Duplicates in remote clusters are prefixed with the cluster name, so that they aren't mistaken for
+// duplicates of a source secret in the remote cluster. Duplicates of source secrets in a remote
+// source cluster are prefixed with the name of the source cluster.
+func (r *SecretReconciler) sourceReference(target targetCluster, source *corev1.Secret) string {
+	if target.isRemote() {
+		return r.ClusterName + "/" + client.ObjectKeyFromObject(source).String()
+	}
+	return sourcePullKey(target, source)
 }
 
+// sourcePullKey returns the key that namespaces use to request source with the pull annotation,
+// e.g. "cert-manager/wildcard" or "central/cert-manager/wildcard" for a source secret in the source cluster central.
+func sourcePullKey(target targetCluster, source *corev1.Secret) string {
+	key := client.ObjectKeyFromObject(source).String()
+	if target.sourceCluster != "" {
+		key = target.sourceCluster + "/" + key
+	}
+	return key
+}
+
+// newTargetDuplicateSecret returns the desired duplicate of source in namespace of target.
+func (r *SecretReconciler) newTargetDuplicateSecret(target targetCluster, source *corev1.Secret,
+	namespace string) *corev1.Secret {
+	duplicate := newDuplicateSecret(source, namespace)
+	duplicate.Annotations[duplicatorFromAnnotationKey] = r.sourceReference(target, source)
+	if ownership := r.ownership.Load(); ownership != nil {
+		ownership.sign(duplicate)
+	}
+	return duplicate
+}
+
+// createDuplicate creates duplicate, or only validates the create in dry-run mode.
+func (r *SecretReconciler) createDuplicate(ctx context.Context, target targetCluster,
+	source, duplicate *corev1.Secret) error {
+	var opts []client.CreateOption
+	if r.DryRun {
+		opts = append(opts, client.DryRunAll)
+	}
+	err := target.Create(ctx, duplicate, opts...)
+	if err != nil {
+		return err
+	}
+	r.recordWrite(ctx, target, operationCreate, source, duplicate)
+	return nil
+}
+
+// updateDuplicate updates duplicate, or only validates the update in dry-run mode.
+func (r *SecretReconciler) updateDuplicate(ctx context.Context, target targetCluster,
+	source, duplicate *corev1.Secret) error {
+	var opts []client.UpdateOption
+	if r.DryRun {
+		opts = append(opts, client.DryRunAll)
+	}
+	err := target.Update(ctx, duplicate, opts...)
+	if err != nil {
+		return err
+	}
+	r.recordWrite(ctx, target, operationUpdate, source, duplicate)
+	return nil
+}
+
+// recreateDuplicate deletes duplicate and creates recreated instead, e.g. because duplicate is immutable.
+// Consumers that start in between don't find the duplicate. The delete fails with a conflict if the secret changed
+// since duplicate was read, so that a secret that replaced the duplicate in the meantime is never deleted.
+// In dry-run mode, the create isn't validated because the dry-run delete leaves duplicate in place.
+func (r *SecretReconciler) recreateDuplicate(ctx context.Context, target targetCluster,
+	source, duplicate, recreated *corev1.Secret) error {
+	err := r.deleteDuplicate(ctx, target, duplicate, client.Preconditions{
+		UID:             &duplicate.UID,
+		ResourceVersion: &duplicate.ResourceVersion,
+	})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return fmt.Errorf("delete: %w", err)
+	}
+	if r.DryRun {
+		r.recordWrite(ctx, target, operationCreate, source, recreated)
+		return nil
+	}
+	if err := r.createDuplicate(ctx, target, source, recreated); err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+	return nil
+}
+
+// deleteDuplicate deletes duplicate with opts, or only validates the delete in dry-run mode.
+func (r *SecretReconciler) deleteDuplicate(ctx context.Context, target targetCluster, duplicate *corev1.Secret,
+	opts ...client.DeleteOption) error {
+	if r.DryRun {
+		opts = append(opts, client.DryRunAll)
+	}
+	err := target.Delete(ctx, duplicate, opts...)
+	if err != nil {
+		return err
+	}
+	// The source may not exist anymore, so the event is attached to the duplicate itself
+	r.recordWrite(ctx, target, operationDelete, duplicate, duplicate)
+	return nil
+}
+
+// recordWrite reports a successful write to a duplicate as log message and metric.
+// In dry-run mode, an event is emitted for the involved object as well.
+// Events aren't emitted for objects in remote clusters because the event recorder writes to the local cluster.
+func (r *SecretReconciler) recordWrite(ctx context.Context, target targetCluster, operation string,
+	involved, duplicate *corev1.Secret) {
+	logger := log.FromContext(ctx).WithValues("cluster", target.name)
+	duplicateKey := client.ObjectKeyFromObject(duplicate).String()
+	observeDuplicateWrite(target.name, operation, r.DryRun)
+	if !r.DryRun {
+		logger.V(1).Info("wrote duplicate", "operation", operation, "duplicate", duplicateKey)
+		return
+	}
+	logger.Info("dry-run: would write duplicate", "operation", operation, "duplicate", duplicateKey)
+	// The involved object is either the duplicate or the source secret
+	if (involved == duplicate && target.isRemote()) || (involved != duplicate && target.sourceCluster != "") {
+		return
+	}
+	reason := map[string]string{
+		operationCreate: reasonDryRunCreate,
+		operationUpdate: reasonDryRunUpdate,
+		operationDelete: reasonDryRunDelete,
+	}[operation]
+	if target.isRemote() {
+		duplicateKey = target.name + "/" + duplicateKey
+	}
+	r.Recorder.Eventf(involved, corev1.EventTypeNormal, reason, "Dry-run: would %s duplicate %s", operation, duplicateKey)
+}
+
+// triggerFullReconcile maps every event to the same request. Every reconcile syncs all source secrets
+// and shares state like the backoff of failed targets, so reconciles must not run concurrently.
+// The work queue never processes a request concurrently and coalesces pending events into one request.
 func (r *SecretReconciler) triggerFullReconcile(ctx context.Context, obj client.Object) []reconcile.Request {
-	// sentinel that means reconcile all secrets (same as if a secret is deleted)
+	// sentinel that means reconcile all secrets
 	return []reconcile.Request{
 		{
 			NamespacedName: client.ObjectKey{
@@ -172,14 +834,39 @@ func (r *SecretReconciler) triggerFullReconcile(ctx context.Context, obj client.
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *SecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.fullReconcileEvents = make(chan event.GenericEvent, 1)
+	r.clusters = newClusterRegistry(mgr.GetScheme(), mgr.GetConfig(), r.enqueueFullReconcile)
+	if err := mgr.Add(r.clusters); err != nil {
+		return err
+	}
+	r.apiReader = mgr.GetAPIReader()
+	r.restarter = newWorkloadRestarter(mgr.GetClient(), r.apiReader, r.Recorder,
+		r.WorkloadRestartQPS, r.WorkloadRestartBurst)
+	if err := mgr.Add(r.restarter); err != nil {
+		return err
+	}
+	if r.ResyncPeriod > 0 {
+		if err := mgr.Add(manager.RunnableFunc(r.resync)); err != nil {
+			return err
+		}
+	}
 	return ctrl.NewControllerManagedBy(mgr).
+		Named("secret").
+		Watches(
+			&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.triggerFullReconcile),
+		).
 		// Trigger reconciliation for namespace events too
 		Watches(
 			&corev1.Namespace{},
 			// Reconcile all secrets in all namespaces
 			handler.EnqueueRequestsFromMapFunc(r.triggerFullReconcile),
 		).
-		For(&corev1.Secret{}).
+		// Trigger reconciliation for changes in remote clusters
+		WatchesRawSource(source.Channel(
+			r.fullReconcileEvents,
+			handler.EnqueueRequestsFromMapFunc(r.triggerFullReconcile),
+		)).
 		Complete(r)
 }
 
@@ -194,6 +881,48 @@ func findNonTerminatingNamespaces(allNamespaces []corev1.Namespace) []*corev1.Na
 	return nonTerminatingNamespaces
 }
 
+func findPausedNamespaces(allNamespaces []corev1.Namespace) map[string]bool {
+	pausedNamespaces := make(map[string]bool)
+	for _, namespace := range allNamespaces {
+		ns := namespace
+		if isPaused(&ns) {
+			pausedNamespaces[namespace.Name] = true
+		}
+	}
+	return pausedNamespaces
+}
+
+func findIgnoredNamespaces(allNamespaces []corev1.Namespace) map[string]bool {
+	ignoredNamespaces := make(map[string]bool)
+	for _, namespace := range allNamespaces {
+		ns := namespace
+		if isNamespaceIgnored(&ns) {
+			ignoredNamespaces[namespace.Name] = true
+		}
+	}
+	return ignoredNamespaces
+}
+
+func countPausedSources(allSources []*corev1.Secret) int {
+	count := 0
+	for _, source := range allSources {
+		if isPaused(source) {
+			count++
+		}
+	}
+	return count
+}
+
+// indexSecrets returns allSecrets by object key.
+func indexSecrets(allSecrets *corev1.SecretList) map[client.ObjectKey]*corev1.Secret {
+	index := make(map[client.ObjectKey]*corev1.Secret, len(allSecrets.Items))
+	for i := range allSecrets.Items {
+		secret := &allSecrets.Items[i]
+		index[client.ObjectKeyFromObject(secret)] = secret
+	}
+	return index
+}
+
 func findAllSourceSecrets(allSecrets *corev1.SecretList) []*corev1.Secret {
 	sources := make([]*corev1.Secret, 0)
 	for _, s := range allSecrets.Items {
@@ -224,6 +953,55 @@ func isSecretDuplicatorSource(secret *corev1.Secret) bool {
 	return ok && value == "true"
 }
 
+// isPaused returns true if a source secret or namespace has the paused annotation.
+func isPaused(obj v1.Object) bool {
+	return obj.GetAnnotations()[duplicatorPausedAnnotationKey] == "true"
+}
+
+// isSourceFrozen returns true if the duplicates of source must not be touched
+// because source is paused, has invalid annotations, its pinned revision can't be resolved
+// or its invalid certificate is held back.
+func (r *SecretReconciler) isSourceFrozen(source *corev1.Secret) bool {
+	return isPaused(source) || validateSecretAnnotations(source) != nil || !isPinResolved(source) ||
+		r.isCertificateHeld(source)
+}
+
+// isNamespaceIgnored returns true if a namespace opted out of receiving duplicates
+// with the ignore annotation or label.
+func isNamespaceIgnored(namespace *corev1.Namespace) bool {
+	return namespace.Annotations[duplicatorIgnoreKey] == "true" || namespace.Labels[duplicatorIgnoreKey] == "true"
+}
+
+// isSourceSyncedTo returns true if duplicates of source should exist in target.
+// Duplicates are synced into the local cluster and the remote clusters listed in the clusters annotation.
+// Source secrets in remote source clusters are only synced into the local cluster.
+func isSourceSyncedTo(source *corev1.Secret, target targetCluster) bool {
+	return !target.isRemote() || slices.Contains(splitList(source.Annotations[duplicatorClustersAnnotationKey]), target.name)
+}
+
+// isSourcePulledBy returns true if the pull annotation of namespace lists sourceKey.
+func isSourcePulledBy(sourceKey string, namespace *corev1.Namespace) bool {
+	for _, pulled := range splitList(namespace.Annotations[duplicatorPullAnnotationKey]) {
+		if pulled == sourceKey {
+			return true
+		}
+	}
+	return false
+}
+
+// isSourcePullAllowed returns true if source allows namespace to pull it.
+func isSourcePullAllowed(source *corev1.Secret, namespace *corev1.Namespace) bool {
+	if source.Annotations[duplicatorPullAllowedAnnotationKey] == "true" {
+		return true
+	}
+	for _, allowed := range splitList(source.Annotations[duplicatorPullAllowedNamespacesAnnotationKey]) {
+		if allowed == namespace.Name {
+			return true
+		}
+	}
+	return false
+}
+
 func isSecretDuplicated(secret *corev1.Secret) bool {
 	if secret.Annotations == nil {
 		return false
@@ -232,6 +1010,57 @@ func isSecretDuplicated(secret *corev1.Secret) bool {
 	return ok && len(strings.Split(value, "/")) == 2
 }
 
+// sourceContentHash returns the hash of the desired content of the duplicates of source.
+// The UID of source isn't part of the hash, duplicates compare it with their source UID annotation instead.
+func sourceContentHash(source *corev1.Secret) string {
+	return contentHash(source.Data, source.Type)
+}
+
+// duplicateContentHash returns the hash of the actual content of duplicate.
+// It differs from the hash in the content hash annotation if the duplicate was edited manually.
+func duplicateContentHash(duplicate *corev1.Secret) string {
+	return contentHash(duplicate.Data, duplicate.Type)
+}
+
+// contentHash returns a stable hash of the data and type of a secret.
+// The hash doesn't depend on the namespace or cluster of a duplicate.
+func contentHash(data map[string][]byte, secretType corev1.SecretType) string {
+	hash := sha256.New()
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		// Length prefixes keep the encoding unambiguous
+		fmt.Fprintf(hash, "%d:%s%d:", len(key), key, len(data[key]))
+		hash.Write(data[key])
+	}
+	fmt.Fprintf(hash, "%d:%s", len(secretType), secretType)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// isSourceRecreated returns true if duplicate was synced from a source secret with a different UID than source.
+// Duplicates without source UID annotation were created by earlier versions of the controller.
+func isSourceRecreated(source, duplicate *corev1.Secret) bool {
+	uid, ok := duplicate.Annotations[duplicatorSourceUIDAnnotationKey]
+	return ok && uid != "" && uid != string(source.UID)
+}
+
+// isImmutable returns true if secret is immutable.
+func isImmutable(secret *corev1.Secret) bool {
+	return secret.Immutable != nil && *secret.Immutable
+}
+
+// isImmutableDuplicate returns true if the duplicates of source must be immutable.
+// The immutable annotation of source overrides whether source itself is immutable.
+func isImmutableDuplicate(source *corev1.Secret) bool {
+	if value, ok := source.Annotations[duplicatorImmutableAnnotationKey]; ok {
+		return value == "true"
+	}
+	return isImmutable(source)
+}
+
 func newDuplicateSecret(source *corev1.Secret, namespace string) *corev1.Secret {
 	duplicate := &corev1.Secret{
 		ObjectMeta: v1.ObjectMeta{
@@ -240,10 +1069,18 @@ func newDuplicateSecret(source *corev1.Secret, namespace string) *corev1.Secret
 			// TODO allow adding annotations and labels to duplicates
 			Annotations: map[string]string{
 				duplicatorFromAnnotationKey: client.ObjectKeyFromObject(source).String(),
+				// The source UID and resource version tell a recreated source secret from a changed one
+				duplicatorSourceUIDAnnotationKey:             string(source.UID),
+				duplicatorSourceResourceVersionAnnotationKey: source.ResourceVersion,
+				duplicatorContentHashAnnotationKey:           sourceContentHash(source),
 			},
 		},
 		Data: source.Data,
 		Type: source.Type,
 	}
+	if isImmutableDuplicate(source) {
+		immutable := true
+		duplicate.Immutable = &immutable
+	}
 	return duplicate
 }
@@ -1,11 +1,19 @@
 package controller
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
 	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 )
 
 func Test_findNonTerminatingNamespaces(t *testing.T) {
@@ -207,6 +215,316 @@ func Test_isSecretDuplicatorSource(t *testing.T) {
 	}
 }
 
+func Test_findPausedNamespaces(t *testing.T) {
+	expected := map[string]bool{"ns1": true}
+	input := []corev1.Namespace{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "ns1",
+				Annotations: map[string]string{
+					duplicatorPausedAnnotationKey: "true",
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "ns2",
+				Annotations: map[string]string{
+					duplicatorPausedAnnotationKey: "false",
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "ns3",
+			},
+		},
+	}
+
+	got := findPausedNamespaces(input)
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, wanted %v", got, expected)
+	}
+}
+
+func Test_isNamespaceIgnored(t *testing.T) {
+	testCases := []struct {
+		name      string
+		namespace *corev1.Namespace
+		want      bool
+	}{
+		{
+			name: "ignore annotation",
+			want: true,
+			namespace: &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "ns",
+					Annotations: map[string]string{duplicatorIgnoreKey: "true"},
+				},
+			},
+		},
+		{
+			name: "ignore label",
+			want: true,
+			namespace: &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "ns",
+					Labels: map[string]string{duplicatorIgnoreKey: "true"},
+				},
+			},
+		},
+		{
+			name: "wrong label value",
+			want: false,
+			namespace: &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "ns",
+					Labels: map[string]string{duplicatorIgnoreKey: "yes"},
+				},
+			},
+		},
+		{
+			name: "no annotations or labels",
+			want: false,
+			namespace: &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "ns",
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := isNamespaceIgnored(tc.namespace)
+			if got != tc.want {
+				t.Errorf("got %v, wanted %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func Test_isTargetNamespace(t *testing.T) {
+	source := func(annotations map[string]string) *corev1.Secret {
+		annotations[duplicatorDuplicateAnnotationKey] = "true"
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "secret1",
+				Namespace:   "source-ns",
+				Annotations: annotations,
+			},
+		}
+	}
+	namespace := func(name string, annotations map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Annotations: annotations,
+			},
+		}
+	}
+	testCases := []struct {
+		name      string
+		mode      Mode
+		target    targetCluster
+		source    *corev1.Secret
+		namespace *corev1.Namespace
+		want      bool
+	}{
+		{
+			name:      "push mode",
+			mode:      ModePush,
+			source:    source(map[string]string{}),
+			namespace: namespace("ns", nil),
+			want:      true,
+		},
+		{
+			name:      "push mode is default",
+			source:    source(map[string]string{}),
+			namespace: namespace("ns", nil),
+			want:      true,
+		},
+		{
+			name:      "source namespace",
+			mode:      ModePush,
+			source:    source(map[string]string{}),
+			namespace: namespace("source-ns", nil),
+			want:      false,
+		},
+		{
+			name:      "source namespace in remote cluster",
+			mode:      ModePush,
+			target:    targetCluster{name: "edge"},
+			source:    source(map[string]string{}),
+			namespace: namespace("source-ns", nil),
+			want:      true,
+		},
+		{
+			name:      "source namespace with source in remote cluster",
+			mode:      ModePush,
+			target:    targetCluster{sourceCluster: "central"},
+			source:    source(map[string]string{}),
+			namespace: namespace("source-ns", nil),
+			want:      true,
+		},
+		{
+			name:      "ignored namespace",
+			mode:      ModePush,
+			source:    source(map[string]string{}),
+			namespace: namespace("ns", map[string]string{duplicatorIgnoreKey: "true"}),
+			want:      false,
+		},
+		{
+			name:      "pull mode without pull annotation",
+			mode:      ModePull,
+			source:    source(map[string]string{duplicatorPullAllowedAnnotationKey: "true"}),
+			namespace: namespace("ns", nil),
+			want:      false,
+		},
+		{
+			name:      "pull mode with pull allowed for all namespaces",
+			mode:      ModePull,
+			source:    source(map[string]string{duplicatorPullAllowedAnnotationKey: "true"}),
+			namespace: namespace("ns", map[string]string{duplicatorPullAnnotationKey: "other/secret, source-ns/secret1"}),
+			want:      true,
+		},
+		{
+			name:      "pull mode with pull allowed for namespace",
+			mode:      ModePull,
+			source:    source(map[string]string{duplicatorPullAllowedNamespacesAnnotationKey: "a,ns"}),
+			namespace: namespace("ns", map[string]string{duplicatorPullAnnotationKey: "source-ns/secret1"}),
+			want:      true,
+		},
+		{
+			name:      "pull mode with source in remote cluster",
+			mode:      ModePull,
+			target:    targetCluster{sourceCluster: "central"},
+			source:    source(map[string]string{duplicatorPullAllowedAnnotationKey: "true"}),
+			namespace: namespace("ns", map[string]string{duplicatorPullAnnotationKey: "central/source-ns/secret1"}),
+			want:      true,
+		},
+		{
+			name:      "pull mode with source in remote cluster requested from local cluster",
+			mode:      ModePull,
+			target:    targetCluster{sourceCluster: "central"},
+			source:    source(map[string]string{duplicatorPullAllowedAnnotationKey: "true"}),
+			namespace: namespace("ns", map[string]string{duplicatorPullAnnotationKey: "source-ns/secret1"}),
+			want:      false,
+		},
+		{
+			name:      "pull mode with pull allowed for other namespaces",
+			mode:      ModePull,
+			source:    source(map[string]string{duplicatorPullAllowedNamespacesAnnotationKey: "a,b"}),
+			namespace: namespace("ns", map[string]string{duplicatorPullAnnotationKey: "source-ns/secret1"}),
+			want:      false,
+		},
+		{
+			name:      "pull mode without pull allowed",
+			mode:      ModePull,
+			source:    source(map[string]string{}),
+			namespace: namespace("ns", map[string]string{duplicatorPullAnnotationKey: "source-ns/secret1"}),
+			want:      false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &SecretReconciler{Mode: tc.mode}
+			got := r.isTargetNamespace(tc.target, tc.source, tc.namespace)
+			if got != tc.want {
+				t.Errorf("got %v, wanted %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func Test_findAllRemoteDuplicateSecrets(t *testing.T) {
+	secret := func(name, source string) corev1.Secret {
+		return corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "ns",
+				Annotations: map[string]string{duplicatorFromAnnotationKey: source},
+			},
+		}
+	}
+	allSecrets := &corev1.SecretList{
+		Items: []corev1.Secret{
+			secret("local-duplicate", "source-ns/secret1"),
+			secret("remote-duplicate", "central/source-ns/secret1"),
+			secret("other-cluster-duplicate", "other/source-ns/secret1"),
+			secret("invalid", "central/secret1"),
+		},
+	}
+
+	got := findAllRemoteDuplicateSecrets(allSecrets, "central")
+	if len(got) != 1 || got[0].Name != "remote-duplicate" {
+		t.Errorf("got %v, wanted only remote-duplicate", got)
+	}
+}
+
+func Test_partitionSourcesByNamespace(t *testing.T) {
+	source := func(namespace string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "secret1",
+				Namespace: namespace,
+				Annotations: map[string]string{
+					duplicatorDuplicateAnnotationKey: "true",
+				},
+			},
+		}
+	}
+	allNamespaces := []corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "listed"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "selected", Labels: map[string]string{"sources": "true"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+	}
+	allSources := []*corev1.Secret{source("listed"), source("selected"), source("other")}
+	testCases := []struct {
+		name         string
+		reconciler   *SecretReconciler
+		wantAllowed  []*corev1.Secret
+		wantRejected []*corev1.Secret
+	}{
+		{
+			name:        "all namespaces allowed by default",
+			reconciler:  &SecretReconciler{},
+			wantAllowed: allSources,
+		},
+		{
+			name:         "namespace list",
+			reconciler:   &SecretReconciler{SourceNamespaces: []string{"listed"}},
+			wantAllowed:  []*corev1.Secret{allSources[0]},
+			wantRejected: []*corev1.Secret{allSources[1], allSources[2]},
+		},
+		{
+			name: "namespace list and selector",
+			reconciler: &SecretReconciler{
+				SourceNamespaces:        []string{"listed"},
+				SourceNamespaceSelector: labels.SelectorFromSet(labels.Set{"sources": "true"}),
+			},
+			wantAllowed:  []*corev1.Secret{allSources[0], allSources[1]},
+			wantRejected: []*corev1.Secret{allSources[2]},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gotAllowed, gotRejected := tc.reconciler.partitionSourcesByNamespace(allSources, allNamespaces)
+			if !reflect.DeepEqual(gotAllowed, tc.wantAllowed) {
+				t.Errorf("got allowed %v, wanted %v", gotAllowed, tc.wantAllowed)
+			}
+			if len(gotRejected) != 0 || len(tc.wantRejected) != 0 {
+				if !reflect.DeepEqual(gotRejected, tc.wantRejected) {
+					t.Errorf("got rejected %v, wanted %v", gotRejected, tc.wantRejected)
+				}
+			}
+		})
+	}
+}
+
 func Test_isSecretDuplicate(t *testing.T) {
 	testCases := []struct {
 		name   string
@@ -265,8 +583,10 @@ func Test_newDuplicateSecret(t *testing.T) {
 	namespace := "another-ns"
 	input := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "secret1",
-			Namespace: "ns",
+			Name:            "secret1",
+			Namespace:       "ns",
+			UID:             "uid-1",
+			ResourceVersion: "42",
 			Annotations: map[string]string{
 				duplicatorDuplicateAnnotationKey: "true",
 			},
@@ -277,7 +597,10 @@ func Test_newDuplicateSecret(t *testing.T) {
 			Name:      "secret1",
 			Namespace: namespace,
 			Annotations: map[string]string{
-				duplicatorFromAnnotationKey: "ns/secret1",
+				duplicatorFromAnnotationKey:                  "ns/secret1",
+				duplicatorSourceUIDAnnotationKey:             "uid-1",
+				duplicatorSourceResourceVersionAnnotationKey: "42",
+				duplicatorContentHashAnnotationKey:           "ba768b331fd86cec803be04e56ab2b3d4c0e98ef4ee4fcd4e72ad7cce61a1d1f",
 			},
 		},
 	}
@@ -286,3 +609,413 @@ func Test_newDuplicateSecret(t *testing.T) {
 		t.Errorf("got %v, wanted %v", got, want)
 	}
 }
+
+func Test_contentHash(t *testing.T) {
+	base := contentHash(map[string][]byte{"a": []byte("bc")}, corev1.SecretTypeOpaque)
+	testCases := []struct {
+		name       string
+		data       map[string][]byte
+		secretType corev1.SecretType
+		wantEqual  bool
+	}{
+		{
+			name:       "same content",
+			data:       map[string][]byte{"a": []byte("bc")},
+			secretType: corev1.SecretTypeOpaque,
+			wantEqual:  true,
+		},
+		{
+			name:       "key and value boundary moved",
+			data:       map[string][]byte{"ab": []byte("c")},
+			secretType: corev1.SecretTypeOpaque,
+		},
+		{
+			name:       "different type",
+			data:       map[string][]byte{"a": []byte("bc")},
+			secretType: corev1.SecretTypeTLS,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := contentHash(tc.data, tc.secretType)
+			if (got == base) != tc.wantEqual {
+				t.Errorf("got hash %s, base hash %s, wanted equal %v", got, base, tc.wantEqual)
+			}
+		})
+	}
+	if contentHash(nil, "") != contentHash(map[string][]byte{}, "") {
+		t.Errorf("wanted equal hashes for nil and empty data")
+	}
+}
+
+func Test_SecretReconciler_dryRun(t *testing.T) {
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret1",
+			Namespace: "ns1",
+			Annotations: map[string]string{
+				duplicatorDuplicateAnnotationKey: "true",
+			},
+		},
+		Data: map[string][]byte{"foo": []byte("bar")},
+	}
+	ownershipKeySecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ownership-key",
+			Namespace: "ns1",
+			UID:       "uid-1",
+		},
+		Data: map[string][]byte{ownershipKeySecretKey: []byte("0123456789abcdef0123456789abcdef")},
+	}
+	orphan := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "orphan",
+			Namespace: "ns2",
+			Annotations: map[string]string{
+				duplicatorFromAnnotationKey: "ns1/orphan",
+			},
+		},
+	}
+	(&ownershipKey{owner: "uid-1", key: ownershipKeySecret.Data[ownershipKeySecretKey]}).sign(orphan)
+	c := fake.NewClientBuilder().WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns2"}},
+		source,
+		orphan,
+		ownershipKeySecret,
+	).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &SecretReconciler{
+		Client:              c,
+		Recorder:            recorder,
+		DryRun:              true,
+		ControllerNamespace: "ns1",
+		OwnershipKeySecret:  "ownership-key",
+	}
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = c.Get(context.Background(), client.ObjectKey{Namespace: "ns2", Name: "secret1"}, &corev1.Secret{})
+	if !k8sErrors.IsNotFound(err) {
+		t.Errorf("expected duplicate not to be created, got err %v", err)
+	}
+	err = c.Get(context.Background(), client.ObjectKeyFromObject(orphan), &corev1.Secret{})
+	if err != nil {
+		t.Errorf("expected orphan not to be deleted, got err %v", err)
+	}
+	close(recorder.Events)
+	var events []string
+	for event := range recorder.Events {
+		events = append(events, event)
+	}
+	want := []string{
+		"Normal DryRunCreate Dry-run: would create duplicate ns2/secret1",
+		"Normal DryRunDelete Dry-run: would delete duplicate ns2/orphan",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got events %v, wanted %v", events, want)
+	}
+}
+
+func Test_SecretReconciler_reconcileSources(t *testing.T) {
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret1",
+			Namespace: "ns1",
+			Annotations: map[string]string{
+				duplicatorDuplicateAnnotationKey: "true",
+			},
+		},
+		Data: map[string][]byte{"foo": []byte("bar")},
+	}
+	unrelated := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret1",
+			Namespace: "ns2",
+		},
+		Data: map[string][]byte{"foo": []byte("unrelated")},
+	}
+	namespaces := []*corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "ns2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "ns3"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "ns4"}},
+	}
+	gets := 0
+	c := fake.NewClientBuilder().
+		WithObjects(source, unrelated).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object,
+				opts ...client.GetOption) error {
+				gets++
+				return c.Get(ctx, key, obj, opts...)
+			},
+		}).
+		Build()
+	r := &SecretReconciler{Client: c, Recorder: record.NewFakeRecorder(10), MaxConcurrentWrites: 2}
+	// The index is stale: it doesn't contain the unrelated secret
+	existingSecrets := map[client.ObjectKey]*corev1.Secret{
+		client.ObjectKeyFromObject(source): source,
+	}
+
+	err := r.reconcileSources(context.Background(), targetCluster{Client: c}, existingSecrets, namespaces,
+		map[string]bool{}, []*corev1.Secret{source})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gets != 0 {
+		t.Errorf("got %d gets, wanted none", gets)
+	}
+	for _, ns := range []string{"ns3", "ns4"} {
+		duplicate := &corev1.Secret{}
+		err = c.Get(context.Background(), client.ObjectKey{Namespace: ns, Name: "secret1"}, duplicate)
+		if err != nil || !reflect.DeepEqual(duplicate.Data, source.Data) {
+			t.Errorf("expected duplicate in %s, got %v, err %v", ns, duplicate.Data, err)
+		}
+	}
+	got := &corev1.Secret{}
+	err = c.Get(context.Background(), client.ObjectKeyFromObject(unrelated), got)
+	if err != nil || !reflect.DeepEqual(got.Data, unrelated.Data) {
+		t.Errorf("expected unrelated secret to be unchanged, got %v, err %v", got.Data, err)
+	}
+}
+
+func Test_SecretReconciler_reconcileDuplicates_recreatedSource(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy SourceRecreatePolicy
+		// acceptedUID is the value of the accept-recreated-from annotation of the source secret
+		acceptedUID string
+		wantUID     string
+		wantEvents  []string
+	}{
+		{
+			name:    "update",
+			policy:  SourceRecreatePolicyUpdate,
+			wantUID: "uid-2",
+			wantEvents: []string{
+				"Warning SourceRecreated Source secret was recreated, previous UID uid-1. Duplicates are updated",
+			},
+		},
+		{
+			name:    "recreate",
+			policy:  SourceRecreatePolicyRecreate,
+			wantUID: "uid-2",
+			wantEvents: []string{
+				"Warning SourceRecreated Source secret was recreated, previous UID uid-1. " +
+					"Duplicates are deleted and created again",
+			},
+		},
+		{
+			name:    "reject",
+			policy:  SourceRecreatePolicyReject,
+			wantUID: "uid-1",
+			wantEvents: []string{
+				"Warning SourceRecreated Source secret was recreated, previous UID uid-1. Duplicates are left untouched",
+			},
+		},
+		{
+			name:        "reject accepted",
+			policy:      SourceRecreatePolicyReject,
+			acceptedUID: "uid-1",
+			wantUID:     "uid-2",
+			wantEvents: []string{
+				"Warning SourceRecreated Source secret was recreated, previous UID uid-1. Duplicates are updated",
+			},
+		},
+		{
+			name:        "reject accepted other source",
+			policy:      SourceRecreatePolicyReject,
+			acceptedUID: "uid-0",
+			wantUID:     "uid-1",
+			wantEvents: []string{
+				"Warning SourceRecreated Source secret was recreated, previous UID uid-1. Duplicates are left untouched",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			source := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "secret1",
+					Namespace:   "ns1",
+					UID:         "uid-2",
+					Annotations: map[string]string{duplicatorDuplicateAnnotationKey: "true"},
+				},
+				Data: map[string][]byte{"foo": []byte("bar")},
+			}
+			previousSource := source.DeepCopy()
+			previousSource.UID = "uid-1"
+			duplicate := newDuplicateSecret(previousSource, "ns2")
+			if tc.acceptedUID != "" {
+				source.Annotations[duplicatorAcceptRecreatedFromAnnotationKey] = tc.acceptedUID
+			}
+			c := fake.NewClientBuilder().WithObjects(duplicate).Build()
+			recorder := record.NewFakeRecorder(10)
+			r := &SecretReconciler{Client: c, Recorder: recorder, SourceRecreatePolicy: tc.policy}
+			namespaces := []*corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "ns2"}}}
+
+			err := r.reconcileDuplicates(context.Background(), targetCluster{Client: c},
+				[]*corev1.Secret{duplicate}, []*corev1.Secret{source}, namespaces, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := &corev1.Secret{}
+			err = c.Get(context.Background(), client.ObjectKeyFromObject(duplicate), got)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if uid := got.Annotations[duplicatorSourceUIDAnnotationKey]; uid != tc.wantUID {
+				t.Errorf("got source UID %q, wanted %q", uid, tc.wantUID)
+			}
+			if events := recordedEvents(recorder); !reflect.DeepEqual(events, tc.wantEvents) {
+				t.Errorf("got events %v, wanted %v", events, tc.wantEvents)
+			}
+			if got := r.recreations.get("ns1/secret1"); got != "uid-1" {
+				t.Errorf("got recreated from %q, wanted uid-1", got)
+			}
+
+			// The event isn't recorded again after the status reports the recreation
+			source.Annotations[duplicatorStatusAnnotationKey] = `{"recreatedFrom":"uid-1"}`
+			err = r.reconcileDuplicates(context.Background(), targetCluster{Client: c},
+				[]*corev1.Secret{duplicate}, []*corev1.Secret{source}, namespaces, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if events := recordedEvents(recorder); len(events) != 0 {
+				t.Errorf("got events %v after the status reported the recreation, wanted none", events)
+			}
+		})
+	}
+}
+
+func Test_SecretReconciler_recreateDuplicate_changed(t *testing.T) {
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "secret1", Namespace: "ns1"},
+		Data:       map[string][]byte{"foo": []byte("new")},
+	}
+	stored := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "secret1", Namespace: "ns2"},
+		Data:       map[string][]byte{"foo": []byte("replaced")},
+	}
+	c := fake.NewClientBuilder().WithObjects(stored).Build()
+	r := &SecretReconciler{Client: c, Recorder: record.NewFakeRecorder(10)}
+	// The duplicate was read before the secret was replaced
+	observed := stored.DeepCopy()
+	observed.ResourceVersion = "998"
+
+	err := r.recreateDuplicate(context.Background(), targetCluster{Client: c}, source, observed,
+		newDuplicateSecret(source, "ns2"))
+	if !k8sErrors.IsConflict(err) {
+		t.Fatalf("got error %v, wanted conflict", err)
+	}
+	got := &corev1.Secret{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(stored), got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(got.Data["foo"]) != "replaced" {
+		t.Errorf("got data %q, wanted the secret that replaced the duplicate to be kept", got.Data["foo"])
+	}
+}
+
+func Test_SecretReconciler_reconcileDuplicates_immutable(t *testing.T) {
+	newSource := func(data string, immutable bool, annotation string) *corev1.Secret {
+		source := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "secret1",
+				Namespace:   "ns1",
+				Annotations: map[string]string{duplicatorDuplicateAnnotationKey: "true"},
+			},
+			Data:      map[string][]byte{"foo": []byte(data)},
+			Immutable: &immutable,
+		}
+		if annotation != "" {
+			source.Annotations[duplicatorImmutableAnnotationKey] = annotation
+		}
+		return source
+	}
+
+	customType := newSource("bar", false, "")
+	customType.Type = "example.com/custom"
+
+	testCases := []struct {
+		name          string
+		duplicate     *corev1.Secret
+		source        *corev1.Secret
+		wantImmutable bool
+		// wantResourceVersion tells whether the duplicate was untouched (999), updated (1000) or recreated (1)
+		wantResourceVersion string
+	}{
+		{
+			name:                "immutable duplicate in sync",
+			duplicate:           newDuplicateSecret(newSource("bar", true, ""), "ns2"),
+			source:              newSource("bar", true, ""),
+			wantImmutable:       true,
+			wantResourceVersion: "999",
+		},
+		{
+			name:                "immutable duplicate out of sync is recreated",
+			duplicate:           newDuplicateSecret(newSource("bar", true, ""), "ns2"),
+			source:              newSource("baz", true, ""),
+			wantImmutable:       true,
+			wantResourceVersion: "1",
+		},
+		{
+			name:                "annotation makes mutable duplicate immutable",
+			duplicate:           newDuplicateSecret(newSource("bar", false, ""), "ns2"),
+			source:              newSource("bar", false, "true"),
+			wantImmutable:       true,
+			wantResourceVersion: "1000",
+		},
+		{
+			name:                "annotation makes immutable duplicate mutable",
+			duplicate:           newDuplicateSecret(newSource("bar", true, ""), "ns2"),
+			source:              newSource("bar", true, "false"),
+			wantResourceVersion: "1",
+		},
+		{
+			name:                "duplicate with other type is recreated",
+			duplicate:           newDuplicateSecret(newSource("bar", false, ""), "ns2"),
+			source:              customType,
+			wantResourceVersion: "1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithObjects(tc.duplicate).Build()
+			r := &SecretReconciler{Client: c, Recorder: record.NewFakeRecorder(10)}
+			namespaces := []*corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "ns2"}}}
+
+			err := r.reconcileDuplicates(context.Background(), targetCluster{Client: c},
+				[]*corev1.Secret{tc.duplicate}, []*corev1.Secret{tc.source}, namespaces, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := &corev1.Secret{}
+			err = c.Get(context.Background(), client.ObjectKeyFromObject(tc.duplicate), got)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got.Data, tc.source.Data) {
+				t.Errorf("got data %v, wanted %v", got.Data, tc.source.Data)
+			}
+			if got.Type != tc.source.Type {
+				t.Errorf("got type %q, wanted %q", got.Type, tc.source.Type)
+			}
+			if isImmutable(got) != tc.wantImmutable {
+				t.Errorf("got immutable %v, wanted %v", isImmutable(got), tc.wantImmutable)
+			}
+			if got.ResourceVersion != tc.wantResourceVersion {
+				t.Errorf("got resource version %s, wanted %s", got.ResourceVersion, tc.wantResourceVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2023 Nick Triller.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const secretWebhookPath = "/validate--v1-secret"
+
+// SecretValidator is a validating admission webhook for secrets.
+// It rejects secrets with invalid duplicator annotations, warns about unknown duplicator annotations,
+// and it protects duplicates from manual edits:
+// Updates of the content of a duplicate and deletes of a duplicate are rejected
+// unless they are performed by one of AllowedUsernames.
+type SecretValidator struct {
+	// AllowedUsernames lists the users that may change and delete duplicates,
+	// e.g. the service account of the controller.
+	AllowedUsernames []string
+
+	decoder admission.Decoder
+}
+
+//+kubebuilder:webhook:path=/validate--v1-secret,mutating=false,failurePolicy=ignore,sideEffects=None,groups="",resources=secrets,verbs=create;update;delete,versions=v1,name=vsecret.duplicator.k8s.nicktriller.com,admissionReviewVersions=v1
+
+// SetupWebhookWithManager registers the webhook with the webhook server of the Manager.
+func (v *SecretValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	v.decoder = admission.NewDecoder(mgr.GetScheme())
+	mgr.GetWebhookServer().Register(secretWebhookPath, &webhook.Admission{Handler: v})
+	return nil
+}
+
+// Handle validates an admission request for a secret.
+func (v *SecretValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if slices.Contains(v.AllowedUsernames, req.UserInfo.Username) {
+		return admission.Allowed("")
+	}
+
+	var secret, oldSecret *corev1.Secret
+	if req.Operation == admissionv1.Create || req.Operation == admissionv1.Update {
+		secret = &corev1.Secret{}
+		err := v.decoder.DecodeRaw(req.Object, secret)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+	if req.Operation == admissionv1.Update || req.Operation == admissionv1.Delete {
+		oldSecret = &corev1.Secret{}
+		err := v.decoder.DecodeRaw(req.OldObject, oldSecret)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	if oldSecret != nil && isProtectedDuplicate(oldSecret) {
+		// Changes to metadata that isn't managed by the controller, e.g. labels added by other tools, are allowed
+		if secret == nil || !isDuplicateContentEqual(oldSecret, secret) {
+			source := oldSecret.Annotations[duplicatorFromAnnotationKey]
+			return admission.Denied(fmt.Sprintf(
+				"secret %s/%s is a duplicate of secret %s managed by k8s-duplicator, change the source secret instead",
+				oldSecret.Namespace, oldSecret.Name, source))
+		}
+	}
+
+	// Only validate annotations if they changed, so that secrets with invalid annotations can still be updated otherwise
+	if secret != nil && (oldSecret == nil ||
+		!reflect.DeepEqual(duplicatorAnnotations(oldSecret), duplicatorAnnotations(secret))) {
+		if err := validateSecretAnnotations(secret); err != nil {
+			return admission.Denied(fmt.Sprintf("secret %s/%s has invalid k8s-duplicator annotations: %s",
+				secret.Namespace, secret.Name, strings.ReplaceAll(err.Error(), "\n", "; ")))
+		}
+		// Unknown annotations may be meant for a newer version of the controller, so they are only warned about
+		if unknown := unknownSecretAnnotations(secret); len(unknown) > 0 {
+			return admission.Allowed("").WithWarnings(fmt.Sprintf("unknown k8s-duplicator annotations are ignored: %s",
+				strings.Join(unknown, ", ")))
+		}
+	}
+
+	return admission.Allowed("")
+}
+
+// isProtectedDuplicate returns true if secret is a duplicate of a source secret in the local cluster
+// or in another cluster, e.g. a duplicate pulled from a source cluster.
+func isProtectedDuplicate(secret *corev1.Secret) bool {
+	_, remote := remoteSourceCluster(secret)
+	return isSecretDuplicated(secret) || remote
+}
+
+// isDuplicateContentEqual returns true if a and b have the same content and duplicator annotations.
+func isDuplicateContentEqual(a, b *corev1.Secret) bool {
+	return reflect.DeepEqual(a.Data, b.Data) &&
+		a.Type == b.Type &&
+		reflect.DeepEqual(duplicatorAnnotations(a), duplicatorAnnotations(b))
+}
+
+// duplicatorAnnotations returns the annotations of secret with the duplicator prefix.
+func duplicatorAnnotations(secret *corev1.Secret) map[string]string {
+	annotations := make(map[string]string)
+	for key, value := range secret.Annotations {
+		if strings.HasPrefix(key, duplicatorAnnotationPrefix) {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
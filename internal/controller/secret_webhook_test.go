@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func Test_SecretValidator_Handle(t *testing.T) {
+	duplicate := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret1",
+			Namespace: "ns",
+			Annotations: map[string]string{
+				duplicatorFromAnnotationKey: "source-ns/secret1",
+			},
+		},
+		Data: map[string][]byte{"foo": []byte("bar")},
+	}
+	modifiedDuplicate := duplicate.DeepCopy()
+	modifiedDuplicate.Data = map[string][]byte{"foo": []byte("modified")}
+	labeledDuplicate := duplicate.DeepCopy()
+	labeledDuplicate.Labels = map[string]string{"team": "a"}
+	pulledDuplicate := duplicate.DeepCopy()
+	pulledDuplicate.Annotations[duplicatorFromAnnotationKey] = "central/source-ns/secret1"
+	modifiedPulledDuplicate := pulledDuplicate.DeepCopy()
+	modifiedPulledDuplicate.Data = map[string][]byte{"foo": []byte("modified")}
+	unrelated := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret1",
+			Namespace: "ns",
+		},
+		Data: map[string][]byte{"foo": []byte("bar")},
+	}
+	modifiedUnrelated := unrelated.DeepCopy()
+	modifiedUnrelated.Data = map[string][]byte{"foo": []byte("modified")}
+	invalidSource := unrelated.DeepCopy()
+	invalidSource.Annotations = map[string]string{duplicatorDuplicateAnnotationKey: "yes"}
+	modifiedInvalidSource := invalidSource.DeepCopy()
+	modifiedInvalidSource.Data = map[string][]byte{"foo": []byte("modified")}
+	unknownSource := unrelated.DeepCopy()
+	unknownSource.Annotations = map[string]string{duplicatorAnnotationPrefix + "newer": "true"}
+
+	testCases := []struct {
+		name      string
+		operation admissionv1.Operation
+		username  string
+		oldObject *corev1.Secret
+		object    *corev1.Secret
+		want      bool
+		// wantWarning is true if the response must contain a warning
+		wantWarning bool
+	}{
+		{
+			name:      "update of duplicate content by other user",
+			operation: admissionv1.Update,
+			username:  "someone",
+			oldObject: duplicate,
+			object:    modifiedDuplicate,
+			want:      false,
+		},
+		{
+			name:      "delete of duplicate by other user",
+			operation: admissionv1.Delete,
+			username:  "someone",
+			oldObject: duplicate,
+			want:      false,
+		},
+		{
+			name:      "update of duplicate content by controller",
+			operation: admissionv1.Update,
+			username:  "controller",
+			oldObject: duplicate,
+			object:    modifiedDuplicate,
+			want:      true,
+		},
+		{
+			name:      "delete of duplicate by controller",
+			operation: admissionv1.Delete,
+			username:  "controller",
+			oldObject: duplicate,
+			want:      true,
+		},
+		{
+			name:      "update of duplicate of source cluster by other user",
+			operation: admissionv1.Update,
+			username:  "someone",
+			oldObject: pulledDuplicate,
+			object:    modifiedPulledDuplicate,
+			want:      false,
+		},
+		{
+			name:      "delete of duplicate of source cluster by other user",
+			operation: admissionv1.Delete,
+			username:  "someone",
+			oldObject: pulledDuplicate,
+			want:      false,
+		},
+		{
+			name:      "update of duplicate labels by other user",
+			operation: admissionv1.Update,
+			username:  "someone",
+			oldObject: duplicate,
+			object:    labeledDuplicate,
+			want:      true,
+		},
+		{
+			name:      "update of unrelated secret by other user",
+			operation: admissionv1.Update,
+			username:  "someone",
+			oldObject: unrelated,
+			object:    modifiedUnrelated,
+			want:      true,
+		},
+		{
+			name:      "create of secret with invalid annotations",
+			operation: admissionv1.Create,
+			username:  "someone",
+			object:    invalidSource,
+			want:      false,
+		},
+		{
+			name:      "update of secret with new invalid annotations",
+			operation: admissionv1.Update,
+			username:  "someone",
+			oldObject: unrelated,
+			object:    invalidSource,
+			want:      false,
+		},
+		{
+			name:      "update of secret with unchanged invalid annotations",
+			operation: admissionv1.Update,
+			username:  "someone",
+			oldObject: invalidSource,
+			object:    modifiedInvalidSource,
+			want:      true,
+		},
+		{
+			name:        "create of secret with unknown annotations",
+			operation:   admissionv1.Create,
+			username:    "someone",
+			object:      unknownSource,
+			want:        true,
+			wantWarning: true,
+		},
+		{
+			name:      "delete of unrelated secret by other user",
+			operation: admissionv1.Delete,
+			username:  "someone",
+			oldObject: unrelated,
+			want:      true,
+		},
+	}
+
+	v := &SecretValidator{
+		AllowedUsernames: []string{"controller"},
+		decoder:          admission.NewDecoder(scheme.Scheme),
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Operation: tc.operation,
+					UserInfo:  authenticationv1.UserInfo{Username: tc.username},
+					OldObject: rawSecret(t, tc.oldObject),
+					Object:    rawSecret(t, tc.object),
+				},
+			}
+			got := v.Handle(context.Background(), req)
+			if got.Allowed != tc.want {
+				t.Errorf("got allowed %v, wanted %v, result: %v", got.Allowed, tc.want, got.Result)
+			}
+			if (len(got.Warnings) > 0) != tc.wantWarning {
+				t.Errorf("got warnings %v, wanted warning %v", got.Warnings, tc.wantWarning)
+			}
+		})
+	}
+}
+
+func rawSecret(t *testing.T, secret *corev1.Secret) runtime.RawExtension {
+	if secret == nil {
+		return runtime.RawExtension{}
+	}
+	raw, err := json.Marshal(secret)
+	if err != nil {
+		t.Fatalf("failed to marshal secret: %v", err)
+	}
+	return runtime.RawExtension{Raw: raw}
+}
@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// sourceStatus is reported as JSON in the status annotation of a source secret.
+// Secrets don't have a status subresource, so an annotation is used instead.
+// The annotation is removed if the status is empty.
+type sourceStatus struct {
+	// Rejected explains why the source secret is not duplicated
+	Rejected string `json:"rejected,omitempty"`
+	// Invalid lists the problems with the annotations of the source secret.
+	// Duplicates of a source secret with invalid annotations are not synced.
+	Invalid string `json:"invalid,omitempty"`
+	// UnknownAnnotations lists the duplicator annotations of the source secret that the controller doesn't know.
+	// They are ignored, e.g. because they are meant for a newer version of the controller.
+	UnknownAnnotations []string `json:"unknownAnnotations,omitempty"`
+	// Paused is true if the source secret has the paused annotation
+	Paused bool `json:"paused,omitempty"`
+	// PausedNamespaces lists the namespaces with the paused annotation in which duplicates are not synced
+	PausedNamespaces []string `json:"pausedNamespaces,omitempty"`
+	// DeniedPullNamespaces lists the namespaces that request the source secret in pull mode,
+	// but aren't allowed to pull it
+	DeniedPullNamespaces []string `json:"deniedPullNamespaces,omitempty"`
+	// ClusterErrors contains the errors syncing duplicates into the remote clusters listed in the clusters annotation,
+	// by name of the kubeconfig secret
+	ClusterErrors map[string]string `json:"clusterErrors,omitempty"`
+	// FailingNamespaces contains the last errors writing duplicates that are retried with backoff,
+	// by namespace, prefixed with the name of the kubeconfig secret for remote clusters
+	FailingNamespaces map[string]string `json:"failingNamespaces,omitempty"`
+	// FailingVersions contains the last errors writing versioned copies like FailingNamespaces,
+	// by namespace and name of the versioned copy
+	FailingVersions map[string]string `json:"failingVersions,omitempty"`
+	// Conflicts contains the secrets that block duplicates because they have the name of the duplicate,
+	// but aren't duplicates of the source secret, by namespace like FailingNamespaces
+	Conflicts map[string]string `json:"conflicts,omitempty"`
+	// VersionConflicts contains the secrets that block versioned copies like Conflicts,
+	// by namespace and name of the versioned copy
+	VersionConflicts map[string]string `json:"versionConflicts,omitempty"`
+	// RecreatedFrom is the UID of the previous source secret if the source secret was recreated
+	// and duplicates were synced from the previous source secret, e.g. because of the reject recreate policy
+	RecreatedFrom string `json:"recreatedFrom,omitempty"`
+	// Rollout is the progress of the staged rollout of the source secret
+	Rollout *rolloutStatus `json:"rollout,omitempty"`
+	// PinnedRevision is the revision the duplicates are synced with if the source secret has the pin-revision annotation
+	PinnedRevision string `json:"pinnedRevision,omitempty"`
+	// PinError explains why the pinned revision can't be resolved. The duplicates are left untouched meanwhile.
+	PinError string `json:"pinError,omitempty"`
+	// InvalidCertificate explains why the certificate of a TLS source secret is invalid
+	InvalidCertificate string `json:"invalidCertificate,omitempty"`
+	// Held is true if the duplicates are held back at their current content because of an invalid certificate
+	Held bool `json:"held,omitempty"`
+}
+
+func (r *SecretReconciler) updateSourceStatuses(ctx context.Context, allSources []*corev1.Secret,
+	allNamespaces []*corev1.Namespace, pausedNamespaces map[string]bool, clusterErrors map[string]error,
+	rollouts map[string]*rolloutStatus, pinErrors map[string]string) error {
+	var errs []error
+	failing := r.backoff.failing()
+	for _, source := range allSources {
+		// Events are only recorded when the reported problems change, not with every reconcile
+		previous := currentSourceStatus(source)
+		status := sourceStatus{
+			Paused:             isPaused(source),
+			UnknownAnnotations: unknownSecretAnnotations(source),
+		}
+		if err := validateSecretAnnotations(source); err != nil {
+			status.Invalid = err.Error()
+			if status.Invalid != previous.Invalid {
+				r.Recorder.Event(source, corev1.EventTypeWarning, reasonInvalidAnnotations,
+					"Source secret has invalid annotations: "+err.Error())
+			}
+		}
+		if len(status.UnknownAnnotations) > 0 && !slices.Equal(status.UnknownAnnotations, previous.UnknownAnnotations) {
+			r.Recorder.Event(source, corev1.EventTypeWarning, reasonUnknownAnnotations,
+				"Source secret has unknown annotations that are ignored: "+strings.Join(status.UnknownAnnotations, ", "))
+		}
+		if err := r.validateCertificate(source); err != nil {
+			status.InvalidCertificate = err.Error()
+			status.Held = r.HoldInvalidCertificates
+			action := "Duplicates are synced anyway"
+			if status.Held {
+				action = "Duplicates are held back"
+			}
+			if status.InvalidCertificate != previous.InvalidCertificate || status.Held != previous.Held {
+				r.Recorder.Eventf(source, corev1.EventTypeWarning, reasonInvalidCertificate,
+					"Source secret has an invalid certificate: %v. %s", err, action)
+			}
+		}
+		for _, namespace := range allNamespaces {
+			if namespace.Name != source.Namespace && pausedNamespaces[namespace.Name] {
+				status.PausedNamespaces = append(status.PausedNamespaces, namespace.Name)
+			}
+			if r.Mode == ModePull && isSourcePulledBy(client.ObjectKeyFromObject(source).String(), namespace) && !isSourcePullAllowed(source, namespace) {
+				status.DeniedPullNamespaces = append(status.DeniedPullNamespaces, namespace.Name)
+			}
+		}
+		for _, cluster := range splitList(source.Annotations[duplicatorClustersAnnotationKey]) {
+			if err, ok := clusterErrors[cluster]; ok {
+				if status.ClusterErrors == nil {
+					status.ClusterErrors = make(map[string]string)
+				}
+				status.ClusterErrors[cluster] = err.Error()
+			}
+		}
+		status.FailingNamespaces = failingTargets(failing, client.ObjectKeyFromObject(source).String(), false)
+		status.FailingVersions = failingTargets(failing, client.ObjectKeyFromObject(source).String(), true)
+		status.Conflicts = r.conflicts.forSource(client.ObjectKeyFromObject(source).String(), false)
+		status.VersionConflicts = r.conflicts.forSource(client.ObjectKeyFromObject(source).String(), true)
+		status.RecreatedFrom = r.recreations.get(client.ObjectKeyFromObject(source).String())
+		status.Rollout = rollouts[client.ObjectKeyFromObject(source).String()]
+		if _, ok := source.Annotations[duplicatorPinRevisionAnnotationKey]; ok && isPinResolved(source) {
+			status.PinnedRevision = sourceRevision(source)
+		}
+		status.PinError = pinErrors[client.ObjectKeyFromObject(source).String()]
+		sort.Strings(status.PausedNamespaces)
+		sort.Strings(status.DeniedPullNamespaces)
+		err := r.updateSourceStatus(ctx, source, status)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("update status of source %s: %w", client.ObjectKeyFromObject(source), err))
+		}
+		r.observeProgress()
+	}
+	return errors.Join(errs...)
+}
+
+// failingTargets returns the errors of the failed duplicates of the local source secret sourceKey,
+// or of its failed versioned copies if versions is set, by status key.
+func failingTargets(failing map[targetKey]targetState, sourceKey string, versions bool) map[string]string {
+	var targets map[string]string
+	for key, state := range failing {
+		if key.source != sourceKey || (key.version != "") != versions {
+			continue
+		}
+		if targets == nil {
+			targets = make(map[string]string)
+		}
+		message := state.lastError.Error()
+		if state.gaveUp {
+			message += " (gave up retrying until the source secret changes)"
+		}
+		targets[key.statusKey()] = message
+	}
+	return targets
+}
+
+// reportRejectedSources reports the rejection in the status of each rejected source secret,
+// and records an event when a source secret is rejected.
+func (r *SecretReconciler) reportRejectedSources(ctx context.Context, rejectedSources []*corev1.Secret) error {
+	var errs []error
+	for _, source := range rejectedSources {
+		message := fmt.Sprintf("namespace %s is not allowed to host source secrets", source.Namespace)
+		// Every reconcile reports the rejection again, the event is only recorded when the status changes
+		if currentSourceStatus(source).Rejected != message {
+			r.Recorder.Event(source, corev1.EventTypeWarning, reasonSourceRejected, "Source secret rejected: "+message)
+		}
+		err := r.updateSourceStatus(ctx, source, sourceStatus{Rejected: message})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("update status of source %s: %w", client.ObjectKeyFromObject(source), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// currentSourceStatus returns the status reported in the status annotation of source.
+func currentSourceStatus(source *corev1.Secret) sourceStatus {
+	var status sourceStatus
+	if value := source.Annotations[duplicatorStatusAnnotationKey]; value != "" {
+		// An invalid status is overwritten with the next status update
+		_ = json.Unmarshal([]byte(value), &status)
+	}
+	return status
+}
+
+// updateSourceStatus patches the status annotation of source if it differs from status.
+func (r *SecretReconciler) updateSourceStatus(ctx context.Context, source *corev1.Secret, status sourceStatus) error {
+	value := ""
+	if !reflect.DeepEqual(status, sourceStatus{}) {
+		encoded, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		value = string(encoded)
+	}
+	if source.Annotations[duplicatorStatusAnnotationKey] == value {
+		return nil
+	}
+
+	patch := client.MergeFrom(source.DeepCopy())
+	if value == "" {
+		delete(source.Annotations, duplicatorStatusAnnotationKey)
+	} else {
+		if source.Annotations == nil {
+			source.Annotations = map[string]string{}
+		}
+		source.Annotations[duplicatorStatusAnnotationKey] = value
+	}
+	var opts []client.PatchOption
+	if r.DryRun {
+		opts = append(opts, client.DryRunAll)
+	}
+	return r.Patch(ctx, source, patch, opts...)
+}
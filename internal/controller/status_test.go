@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// recordedEvents returns the events recorded by recorder so far.
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func Test_SecretReconciler_reportRejectedSources(t *testing.T) {
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "secret1",
+			Namespace:   "ns1",
+			Annotations: map[string]string{duplicatorDuplicateAnnotationKey: "true"},
+		},
+	}
+	recorder := record.NewFakeRecorder(10)
+	r := &SecretReconciler{Client: fake.NewClientBuilder().WithObjects(source).Build(), Recorder: recorder}
+
+	for i, wantEvents := range []int{1, 0} {
+		if err := r.reportRejectedSources(context.Background(), []*corev1.Secret{source}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := recordedEvents(recorder); len(got) != wantEvents {
+			t.Errorf("reconcile %d: got events %v, wanted %d", i, got, wantEvents)
+		}
+	}
+	if got := currentSourceStatus(source).Rejected; got != "namespace ns1 is not allowed to host source secrets" {
+		t.Errorf("got rejected status %q", got)
+	}
+}
+
+func Test_SecretReconciler_updateSourceStatuses_events(t *testing.T) {
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret1",
+			Namespace: "ns1",
+			Annotations: map[string]string{
+				duplicatorDuplicateAnnotationKey:       "true",
+				duplicatorPausedAnnotationKey:          "yes",
+				duplicatorAnnotationPrefix + "unknown": "true",
+			},
+		},
+	}
+	recorder := record.NewFakeRecorder(10)
+	r := &SecretReconciler{Client: fake.NewClientBuilder().WithObjects(source).Build(), Recorder: recorder}
+
+	for i, wantEvents := range []int{2, 0} {
+		err := r.updateSourceStatuses(context.Background(), []*corev1.Secret{source}, nil, nil, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := recordedEvents(recorder); len(got) != wantEvents {
+			t.Errorf("reconcile %d: got events %v, wanted %d", i, got, wantEvents)
+		}
+	}
+
+	// Unknown annotations alone are reported, but don't freeze the source secret
+	source.Annotations[duplicatorPausedAnnotationKey] = "false"
+	if r.isSourceFrozen(source) {
+		t.Errorf("got frozen source with unknown annotation")
+	}
+	status := currentSourceStatus(source)
+	if len(status.UnknownAnnotations) != 1 || status.Invalid == "" {
+		t.Errorf("got status %+v, wanted invalid and unknown annotations", status)
+	}
+}
+
+func Test_SecretReconciler_updateSourceStatuses_invalidCertificate(t *testing.T) {
+	source := newTLSSecret(newTestCertificate(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	recorder := record.NewFakeRecorder(10)
+	r := &SecretReconciler{Client: fake.NewClientBuilder().WithObjects(source).Build(), Recorder: recorder}
+
+	steps := []struct {
+		hold       bool
+		wantEvents int
+	}{
+		{hold: false, wantEvents: 1},
+		{hold: false, wantEvents: 0},
+		// Holding the duplicates back changes the status, so the event is recorded again
+		{hold: true, wantEvents: 1},
+		{hold: true, wantEvents: 0},
+	}
+	for i, step := range steps {
+		r.HoldInvalidCertificates = step.hold
+		err := r.updateSourceStatuses(context.Background(), []*corev1.Secret{source}, nil, nil, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := recordedEvents(recorder); len(got) != step.wantEvents {
+			t.Errorf("reconcile %d: got events %v, wanted %d", i, got, step.wantEvents)
+		}
+	}
+	if status := currentSourceStatus(source); status.InvalidCertificate == "" || !status.Held {
+		t.Errorf("got status %+v, wanted held invalid certificate", status)
+	}
+}
@@ -18,7 +18,9 @@ package controller
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"path/filepath"
 	"reflect"
 	"runtime"
@@ -32,6 +34,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -53,6 +56,18 @@ var k8sClient client.Client
 var testEnv *envtest.Environment
 var k8sManagerCancel context.CancelFunc
 
+// The remote test environment is a second cluster that duplicates are synced into,
+// and source secrets are synced from
+var remoteTestEnv *envtest.Environment
+var remoteK8sClient client.Client
+
+const (
+	testClusterName          = "local"
+	testControllerNamespace  = "kube-system"
+	testKubeconfigSecretName = "remote"
+	testOwnershipKeySecret   = "ownership-key"
+)
+
 func TestControllers(t *testing.T) {
 	RegisterFailHandler(Fail)
 	configureGomega()
@@ -81,6 +96,10 @@ var _ = BeforeSuite(func() {
 		// the tests directly. When we run make test it will be setup and used automatically.
 		BinaryAssetsDirectory: filepath.Join("..", "..", "bin", "k8s",
 			fmt.Sprintf("1.28.0-%s-%s", runtime.GOOS, runtime.GOARCH)),
+
+		WebhookInstallOptions: envtest.WebhookInstallOptions{
+			Paths: []string{filepath.Join("..", "..", "config", "webhook")},
+		},
 	}
 
 	var err error
@@ -95,26 +114,79 @@ var _ = BeforeSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 	Expect(k8sClient).NotTo(BeNil())
 
+	By("bootstrapping remote test environment")
+	remoteTestEnv = &envtest.Environment{
+		BinaryAssetsDirectory: testEnv.BinaryAssetsDirectory,
+	}
+	remoteCfg, err := remoteTestEnv.Start()
+	Expect(err).NotTo(HaveOccurred())
+	remoteK8sClient, err = client.New(remoteCfg, client.Options{Scheme: scheme.Scheme})
+	Expect(err).NotTo(HaveOccurred())
+
 	k8sManager, err := ctrl.NewManager(cfg, ctrl.Options{
 		Scheme: scheme.Scheme,
 		Metrics: metricsserver.Options{
 			BindAddress: "localhost:8080",
 		},
 		HealthProbeBindAddress: "localhost:8081",
+		WebhookServer: webhook.NewServer(webhook.Options{
+			Host:    testEnv.WebhookInstallOptions.LocalServingHost,
+			Port:    testEnv.WebhookInstallOptions.LocalServingPort,
+			CertDir: testEnv.WebhookInstallOptions.LocalServingCertDir,
+		}),
 	})
 	Expect(err).ToNot(HaveOccurred())
 
 	err = (&SecretReconciler{
-		Client: k8sManager.GetClient(),
-		Scheme: k8sManager.GetScheme(),
+		Client:   k8sManager.GetClient(),
+		Scheme:   k8sManager.GetScheme(),
+		Recorder: k8sManager.GetEventRecorderFor("k8s-duplicator"),
+
+		ClusterName:         testClusterName,
+		ControllerNamespace: testControllerNamespace,
+		SourceClusters:      []string{testKubeconfigSecretName},
+		OwnershipKeySecret:  testOwnershipKeySecret,
 	}).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 
+	// The envtest admin user is used by the manager and the tests
+	err = (&SecretValidator{
+		AllowedUsernames: []string{"admin"},
+	}).SetupWebhookWithManager(k8sManager)
+	Expect(err).ToNot(HaveOccurred())
+
+	// Store kubeconfig of the remote cluster
+	remoteUser, err := remoteTestEnv.AddUser(envtest.User{Name: "duplicator", Groups: []string{"system:masters"}}, nil)
+	Expect(err).NotTo(HaveOccurred())
+	kubeconfig, err := remoteUser.KubeConfig()
+	Expect(err).NotTo(HaveOccurred())
+	err = k8sClient.Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      testKubeconfigSecretName,
+			Namespace: testControllerNamespace,
+			Labels:    map[string]string{duplicatorKubeconfigLabelKey: "true"},
+		},
+		Data: map[string][]byte{kubeconfigSecretKey: kubeconfig},
+	})
+	Expect(err).NotTo(HaveOccurred())
+
 	go func() {
 		defer GinkgoRecover()
 		err = k8sManager.Start(ctx)
 		Expect(err).ToNot(HaveOccurred(), "failed to run manager")
 	}()
+
+	// Wait for the webhook server to get ready
+	dialer := &net.Dialer{Timeout: time.Second}
+	addrPort := fmt.Sprintf("%s:%d", testEnv.WebhookInstallOptions.LocalServingHost,
+		testEnv.WebhookInstallOptions.LocalServingPort)
+	Eventually(func() error {
+		conn, err := tls.DialWithDialer(dialer, "tcp", addrPort, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}).Should(Succeed())
 })
 
 var _ = AfterSuite(func() {
@@ -122,6 +194,8 @@ var _ = AfterSuite(func() {
 	k8sManagerCancel()
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
+	err = remoteTestEnv.Stop()
+	Expect(err).NotTo(HaveOccurred())
 })
 
 var _ = Describe("Secret controller", func() {
@@ -157,6 +231,10 @@ var _ = Describe("Secret controller", func() {
 			err = k8sClient.List(ctx, allSecrets)
 			Expect(err).NotTo(HaveOccurred())
 			for _, secret := range allSecrets.Items {
+				if secret.Namespace == testControllerNamespace &&
+					(secret.Name == testKubeconfigSecretName || secret.Name == testOwnershipKeySecret) {
+					continue
+				}
 				err := k8sClient.Delete(ctx, &secret)
 				if err != nil && !k8sErrors.IsNotFound(err) {
 					Expect(err).NotTo(HaveOccurred())
@@ -247,25 +325,43 @@ var _ = Describe("Secret controller", func() {
 		})
 
 		It("should revert change to duplicate", func() {
-			// Update duplicate
-			ns := "kube-system"
-			modifiedDataKey := "modified"
-			updatedDuplicate := &corev1.Secret{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      sourceSecrets[0].Name,
-					Namespace: ns,
-					Annotations: map[string]string{
-						duplicatorFromAnnotationKey: client.ObjectKeyFromObject(sourceSecrets[0]).String(),
-					},
-				},
-				Data: map[string][]byte{modifiedDataKey: []byte("val")},
-			}
-			err := k8sClient.Update(ctx, updatedDuplicate)
+			// Update duplicate, keeping its ownership marker
+			updatedDuplicate := &corev1.Secret{}
+			key := client.ObjectKey{Namespace: "kube-system", Name: sourceSecrets[0].Name}
+			err := k8sClient.Get(ctx, key, updatedDuplicate)
+			Expect(err).NotTo(HaveOccurred())
+			updatedDuplicate.Data = map[string][]byte{"modified": []byte("val")}
+			err = k8sClient.Update(ctx, updatedDuplicate)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(assertDuplicatesExistAndMatchSourceSecrets(ctx, sourceSecrets)).Should(Succeed())
 			Expect(assertUnrelatedSecretsUnchanged(ctx, unrelatedSecrets)()).To(Succeed())
 		})
 
+		It("should reject manual edits and deletes of duplicates", func() {
+			intruder, err := testEnv.AddUser(envtest.User{Name: "intruder", Groups: []string{"system:masters"}}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			intruderClient, err := client.New(intruder.Config(), client.Options{Scheme: scheme.Scheme})
+			Expect(err).NotTo(HaveOccurred())
+			duplicate := &corev1.Secret{}
+			err = intruderClient.Get(ctx, client.ObjectKey{Namespace: "kube-system", Name: sourceSecrets[0].Name}, duplicate)
+			Expect(err).NotTo(HaveOccurred())
+			// Update duplicate content
+			modifiedDuplicate := duplicate.DeepCopy()
+			modifiedDuplicate.Data = map[string][]byte{"modified": []byte("val")}
+			err = intruderClient.Update(ctx, modifiedDuplicate)
+			Expect(k8sErrors.IsForbidden(err)).To(BeTrue(), "expected forbidden error, got %v", err)
+			Expect(err.Error()).To(ContainSubstring("change the source secret instead"))
+			// Delete duplicate
+			err = intruderClient.Delete(ctx, duplicate)
+			Expect(k8sErrors.IsForbidden(err)).To(BeTrue(), "expected forbidden error, got %v", err)
+			// Labels can still be changed
+			labeledDuplicate := duplicate.DeepCopy()
+			labeledDuplicate.Labels = map[string]string{"team": "a"}
+			err = intruderClient.Update(ctx, labeledDuplicate)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(assertDuplicatesExistAndMatchSourceSecrets(ctx, sourceSecrets)()).To(Succeed())
+		})
+
 		It("should not clobber existing secret with duplicate or delete unrelated secret", func() {
 			// Create an unrelated secret with same name as duplicate will have
 			secretName := "i-am-a-secret"
@@ -314,6 +410,40 @@ var _ = Describe("Secret controller", func() {
 			Expect(gotSecret.Data).To(Equal(unrelatedSecret.Data))
 		})
 
+		It("should not touch secrets with a source annotation but without ownership marker", func() {
+			// Forge a duplicate of a source secret that doesn't exist
+			forged := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "forged",
+					Namespace: "kube-system",
+					Annotations: map[string]string{
+						duplicatorFromAnnotationKey: sourceNamespace + "/forged",
+					},
+				},
+				Data: map[string][]byte{"asd": []byte("asd")},
+			}
+			err := k8sClient.Create(ctx, forged)
+			Expect(err).NotTo(HaveOccurred())
+			// Forge a duplicate of an existing source secret with an invalid signature
+			forgedSignature := &corev1.Secret{}
+			key := client.ObjectKey{Namespace: "ns-1", Name: sourceSecrets[0].Name}
+			err = k8sClient.Get(ctx, key, forgedSignature)
+			Expect(err).NotTo(HaveOccurred())
+			forgedSignature.Annotations[duplicatorSignatureAnnotationKey] = "invalid"
+			forgedSignature.Data = map[string][]byte{"asd": []byte("asd")}
+			err = k8sClient.Update(ctx, forgedSignature)
+			Expect(err).NotTo(HaveOccurred())
+
+			Consistently(func(g Gomega) {
+				gotSecret := &corev1.Secret{}
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(forged), gotSecret)
+				g.Expect(err).NotTo(HaveOccurred())
+				err = k8sClient.Get(ctx, key, gotSecret)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(gotSecret.Data).To(Equal(forgedSignature.Data))
+			}, 2*time.Second).Should(Succeed())
+		})
+
 		It("should update duplicates when source secret changes", func() {
 			updatedSource := &corev1.Secret{
 				ObjectMeta: metav1.ObjectMeta{
@@ -438,6 +568,152 @@ var _ = Describe("Secret controller", func() {
 			Expect(assertUnrelatedSecretsUnchanged(ctx, unrelatedSecrets)()).To(Succeed())
 		})
 
+		It("should not update duplicates of a paused source", func() {
+			// Pause source and change its data
+			pausedSource := &corev1.Secret{}
+			err := k8sClient.Get(ctx, client.ObjectKeyFromObject(sourceSecrets[0]), pausedSource)
+			Expect(err).NotTo(HaveOccurred())
+			pausedSource.Annotations[duplicatorPausedAnnotationKey] = "true"
+			pausedSource.Data = map[string][]byte{"zzz": []byte("xxx")}
+			err = k8sClient.Update(ctx, pausedSource)
+			Expect(err).NotTo(HaveOccurred())
+			// Verify duplicates keep the old data
+			time.Sleep(1 * time.Second)
+			gotSecret := &corev1.Secret{}
+			err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "kube-system", Name: pausedSource.Name}, gotSecret)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gotSecret.Data).To(Equal(sourceSecrets[0].Data))
+			// Verify paused state is reported in status
+			Eventually(func() (string, error) {
+				gotSource := &corev1.Secret{}
+				err := k8sClient.Get(ctx, client.ObjectKeyFromObject(pausedSource), gotSource)
+				return gotSource.Annotations[duplicatorStatusAnnotationKey], err
+			}).Should(Equal(`{"paused":true}`))
+		})
+
+		It("should not touch duplicates in a paused namespace", func() {
+			pausedNamespace := &corev1.Namespace{}
+			err := k8sClient.Get(ctx, client.ObjectKey{Name: "ns-0"}, pausedNamespace)
+			Expect(err).NotTo(HaveOccurred())
+			pausedNamespace.Annotations = map[string]string{duplicatorPausedAnnotationKey: "true"}
+			err = k8sClient.Update(ctx, pausedNamespace)
+			Expect(err).NotTo(HaveOccurred())
+			// Change source data
+			updatedSource := &corev1.Secret{}
+			err = k8sClient.Get(ctx, client.ObjectKeyFromObject(sourceSecrets[0]), updatedSource)
+			Expect(err).NotTo(HaveOccurred())
+			updatedSource.Data = map[string][]byte{"zzz": []byte("xxx")}
+			err = k8sClient.Update(ctx, updatedSource)
+			Expect(err).NotTo(HaveOccurred())
+			// Verify duplicate in other namespace is updated while duplicate in paused namespace is unchanged
+			Eventually(func() (map[string][]byte, error) {
+				gotSecret := &corev1.Secret{}
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "ns-1", Name: updatedSource.Name}, gotSecret)
+				return gotSecret.Data, err
+			}).Should(Equal(updatedSource.Data))
+			gotSecret := &corev1.Secret{}
+			err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "ns-0", Name: updatedSource.Name}, gotSecret)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gotSecret.Data).To(Equal(sourceSecrets[0].Data))
+			// Unpause namespace, namespaces can't be deleted in envtest
+			delete(pausedNamespace.Annotations, duplicatorPausedAnnotationKey)
+			err = k8sClient.Update(ctx, pausedNamespace)
+			Expect(err).NotTo(HaveOccurred())
+			sourceSecretsUpdated := make([]*corev1.Secret, len(sourceSecrets))
+			copy(sourceSecretsUpdated, sourceSecrets)
+			sourceSecretsUpdated[0] = updatedSource
+			Eventually(assertDuplicatesExistAndMatchSourceSecrets(ctx, sourceSecretsUpdated)).Should(Succeed())
+		})
+
+		It("should delete duplicates in a namespace that opted out", func() {
+			ignoredNamespace := &corev1.Namespace{}
+			err := k8sClient.Get(ctx, client.ObjectKey{Name: "ns-2"}, ignoredNamespace)
+			Expect(err).NotTo(HaveOccurred())
+			ignoredNamespace.Labels[duplicatorIgnoreKey] = "true"
+			err = k8sClient.Update(ctx, ignoredNamespace)
+			Expect(err).NotTo(HaveOccurred())
+			// Verify duplicates are deleted from the ignored namespace
+			Eventually(func() error {
+				for _, sourceSecret := range sourceSecrets {
+					err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "ns-2", Name: sourceSecret.Name}, &corev1.Secret{})
+					if !k8sErrors.IsNotFound(err) {
+						return fmt.Errorf("duplicate ns-2/%s still exists", sourceSecret.Name)
+					}
+				}
+				return nil
+			}).Should(Succeed())
+			Expect(assertUnrelatedSecretsUnchanged(ctx, unrelatedSecrets)()).To(Succeed())
+			// Opt in again, namespaces can't be deleted in envtest
+			delete(ignoredNamespace.Labels, duplicatorIgnoreKey)
+			err = k8sClient.Update(ctx, ignoredNamespace)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(assertDuplicatesExistAndMatchSourceSecrets(ctx, sourceSecrets)).Should(Succeed())
+		})
+
+		It("should sync duplicates into a remote cluster", func() {
+			// Sync source into remote cluster
+			source := &corev1.Secret{}
+			err := k8sClient.Get(ctx, client.ObjectKeyFromObject(sourceSecrets[0]), source)
+			Expect(err).NotTo(HaveOccurred())
+			source.Annotations[duplicatorClustersAnnotationKey] = testKubeconfigSecretName
+			err = k8sClient.Update(ctx, source)
+			Expect(err).NotTo(HaveOccurred())
+			// The source namespace of the remote cluster receives a duplicate too
+			for _, ns := range []string{sourceNamespace, "kube-system"} {
+				Eventually(func(g Gomega) {
+					gotSecret := &corev1.Secret{}
+					err := remoteK8sClient.Get(ctx, client.ObjectKey{Namespace: ns, Name: source.Name}, gotSecret)
+					g.Expect(err).NotTo(HaveOccurred())
+					g.Expect(gotSecret.Data).To(Equal(source.Data))
+					g.Expect(gotSecret.Annotations[duplicatorFromAnnotationKey]).
+						To(Equal(testClusterName + "/" + client.ObjectKeyFromObject(source).String()))
+				}).Should(Succeed())
+			}
+
+			// Stop syncing source into remote cluster
+			delete(source.Annotations, duplicatorClustersAnnotationKey)
+			err = k8sClient.Update(ctx, source)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func() bool {
+				err := remoteK8sClient.Get(ctx, client.ObjectKey{Namespace: "kube-system", Name: source.Name},
+					&corev1.Secret{})
+				return k8sErrors.IsNotFound(err)
+			}).Should(BeTrue())
+		})
+
+		It("should sync source secrets from a remote cluster", func() {
+			remoteSource := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "remote-source",
+					Namespace: "kube-public",
+					Annotations: map[string]string{
+						duplicatorDuplicateAnnotationKey: "true",
+					},
+				},
+				Data: map[string][]byte{"foo": []byte("remote")},
+			}
+			err := remoteK8sClient.Create(ctx, remoteSource)
+			Expect(err).NotTo(HaveOccurred())
+			// The namespace of the remote source secret receives a duplicate too
+			for _, ns := range []string{"kube-public", "ns-0"} {
+				Eventually(func(g Gomega) {
+					gotSecret := &corev1.Secret{}
+					err := k8sClient.Get(ctx, client.ObjectKey{Namespace: ns, Name: remoteSource.Name}, gotSecret)
+					g.Expect(err).NotTo(HaveOccurred())
+					g.Expect(gotSecret.Data).To(Equal(remoteSource.Data))
+					g.Expect(gotSecret.Annotations[duplicatorFromAnnotationKey]).
+						To(Equal(testKubeconfigSecretName + "/kube-public/remote-source"))
+				}).Should(Succeed())
+			}
+
+			err = remoteK8sClient.Delete(ctx, remoteSource)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "ns-0", Name: remoteSource.Name}, &corev1.Secret{})
+				return k8sErrors.IsNotFound(err)
+			}).Should(BeTrue())
+		})
+
 		It("should delete duplicates when source secret annotation is removed", func() {
 			// Remove duplicate=true annotation
 			modifiedDataKey := "modified"
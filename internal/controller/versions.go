@@ -0,0 +1,266 @@
+package controller
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	defaultVersionRetention = 24 * time.Hour
+	// versionHashLength is the length of the abbreviated revision in the names of versioned copies
+	versionHashLength = 10
+)
+
+// isVersionedNames returns true if source opted in to versioned copies with the versioned-names annotation.
+// Each revision of source is then duplicated as versioned copy named <name>-<revision> in addition to the duplicate,
+// which serves as stable alias. The current versioned copy has the current label "true".
+func isVersionedNames(source *corev1.Secret) bool {
+	return source.Annotations[duplicatorVersionedNamesAnnotationKey] == "true"
+}
+
+// isVersionedCopy returns true if duplicate is a versioned copy instead of a regular duplicate.
+func isVersionedCopy(duplicate *corev1.Secret) bool {
+	_, ok := duplicate.Labels[duplicatorVersionLabelKey]
+	return ok
+}
+
+// versionedName returns the name of the versioned copy of the current revision of source.
+func versionedName(source *corev1.Secret) string {
+	return source.Name + "-" + sourceRevision(source)[:versionHashLength]
+}
+
+// partitionVersionedCopies splits allDuplicates into the regular duplicates and the versioned copies.
+func partitionVersionedCopies(allDuplicates []*corev1.Secret) (duplicates, versions []*corev1.Secret) {
+	for _, duplicate := range allDuplicates {
+		if isVersionedCopy(duplicate) {
+			versions = append(versions, duplicate)
+		} else {
+			duplicates = append(duplicates, duplicate)
+		}
+	}
+	return duplicates, versions
+}
+
+// newTargetVersionedCopy returns the desired versioned copy of the current revision of source in namespace of target.
+func (r *SecretReconciler) newTargetVersionedCopy(target targetCluster, source *corev1.Secret,
+	namespace string) *corev1.Secret {
+	version := newDuplicateSecret(source, namespace)
+	version.Name = versionedName(source)
+	version.Annotations[duplicatorFromAnnotationKey] = r.sourceReference(target, source)
+	version.Labels = map[string]string{
+		duplicatorVersionLabelKey: sourceRevision(source)[:versionHashLength],
+		duplicatorCurrentLabelKey: "true",
+	}
+	if ownership := r.ownership.Load(); ownership != nil {
+		ownership.sign(version)
+	}
+	return version
+}
+
+// reconcileVersions creates the versioned copies of the current revisions of allSources, moves the current label
+// to them, and deletes superseded versioned copies after VersionRetention.
+// Versioned copies are never updated with new data, a new revision gets a new versioned copy instead.
+// A manually edited versioned copy of the current revision is recreated, and a secret that isn't a versioned copy
+// but has the name of one is reported as a conflict. existingSecrets contains all secrets in the target cluster.
+func (r *SecretReconciler) reconcileVersions(ctx context.Context, target targetCluster,
+	existingSecrets map[client.ObjectKey]*corev1.Secret, allVersions, allSources []*corev1.Secret,
+	allNamespaces []*corev1.Namespace, pausedNamespaces map[string]bool) error {
+	sourceSecretsMap := make(map[string]*corev1.Secret)
+	for _, source := range allSources {
+		sourceSecretsMap[r.sourceReference(target, source)] = source
+	}
+	namespacesMap := make(map[string]*corev1.Namespace)
+	for _, namespace := range allNamespaces {
+		namespacesMap[namespace.Name] = namespace
+	}
+	existing := make(map[client.ObjectKey]bool)
+	for _, version := range allVersions {
+		existing[client.ObjectKeyFromObject(version)] = true
+	}
+
+	pool := newWorkerPool(r.MaxConcurrentWrites)
+	// Create missing versioned copies of the current revisions
+	for _, source := range allSources {
+		if !isVersionedNames(source) || r.isSourceFrozen(source) || !isSourceSyncedTo(source, target) {
+			continue
+		}
+		for _, namespace := range allNamespaces {
+			if pausedNamespaces[namespace.Name] || !r.isTargetNamespace(target, source, namespace) ||
+				!isInRolloutWave(target.rollouts[r.sourceReference(target, source)], target.waves, namespace.Name) {
+				continue
+			}
+			versionKey := client.ObjectKey{Namespace: namespace.Name, Name: versionedName(source)}
+			key := targetKey{
+				cluster:   target.name,
+				source:    sourcePullKey(target, source),
+				namespace: versionKey.Namespace,
+				version:   versionKey.Name,
+			}
+			if existing[versionKey] {
+				continue
+			}
+			if secret, ok := existingSecrets[versionKey]; ok {
+				// The existing secret is never overwritten if it isn't an owned versioned copy of the source secret
+				r.reportConflict(ctx, target, key, source, secret)
+				continue
+			}
+			r.writeTarget(ctx, pool, key, sourceContentHash(source), func() error {
+				version := r.newTargetVersionedCopy(target, source, namespace.Name)
+				err := r.createDuplicate(ctx, target, source, version)
+				if k8sErrors.IsAlreadyExists(err) {
+					log.FromContext(ctx).V(1).Info("versioned copy already exists", "cluster", target.name,
+						"version", versionKey.String())
+					return nil
+				}
+				if err != nil {
+					return fmt.Errorf("create versioned copy %s of source %s: %w",
+						versionKey, client.ObjectKeyFromObject(source), err)
+				}
+				return nil
+			})
+		}
+	}
+
+	retention := cmp.Or(r.VersionRetention, defaultVersionRetention)
+	now := time.Now()
+	for _, version := range allVersions {
+		namespace, ok := namespacesMap[version.Namespace]
+		if !ok || pausedNamespaces[version.Namespace] ||
+			(isNamespaceIgnored(namespace) && r.KeepDuplicatesInIgnoredNamespaces) {
+			continue
+		}
+		fromAnnotation := version.Annotations[duplicatorFromAnnotationKey]
+		source, ok := sourceSecretsMap[fromAnnotation]
+		if ok && r.isSourceFrozen(source) {
+			continue
+		}
+		key := targetKey{
+			cluster:   target.name,
+			source:    fromAnnotation,
+			namespace: version.Namespace,
+			version:   version.Name,
+		}
+		if ok {
+			key.source = sourcePullKey(target, source)
+		}
+		if !ok || !isSourceSyncedTo(source, target) || !r.isTargetNamespace(target, source, namespace) {
+			// Versioned copies of deleted source secrets are deleted like orphaned duplicates
+			r.writeTarget(ctx, pool, key, "", func() error {
+				return r.deleteVersion(ctx, target, version)
+			})
+			continue
+		}
+		if !isInRolloutWave(target.rollouts[fromAnnotation], target.waves, version.Namespace) {
+			continue
+		}
+		if isVersionedNames(source) && version.Name == versionedName(source) {
+			if duplicateContentHash(version) != sourceContentHash(source) {
+				// The versioned copy of the current revision was edited manually
+				r.writeTarget(ctx, pool, key, sourceContentHash(source), func() error {
+					recreated := r.newTargetVersionedCopy(target, source, version.Namespace)
+					err := r.recreateDuplicate(ctx, target, source, version, recreated)
+					if err != nil {
+						return fmt.Errorf("recreate edited versioned copy %s of source %s: %w",
+							client.ObjectKeyFromObject(version), client.ObjectKeyFromObject(source), err)
+					}
+					return nil
+				})
+				continue
+			}
+			_, superseded := version.Annotations[duplicatorSupersededAtAnnotationKey]
+			if version.Labels[duplicatorCurrentLabelKey] != "true" || superseded {
+				// The source secret was reverted to the revision of a superseded versioned copy
+				r.writeTarget(ctx, pool, key, sourceContentHash(source), func() error {
+					return r.labelVersion(ctx, target, source, version, true, time.Time{})
+				})
+			}
+			continue
+		}
+
+		supersededAt, err := time.Parse(time.RFC3339, version.Annotations[duplicatorSupersededAtAnnotationKey])
+		if err != nil {
+			// Superseded versioned copies stay current until the versioned copy of the current revision exists,
+			// so that the current label always selects a versioned copy
+			currentKey := client.ObjectKey{Namespace: version.Namespace, Name: versionedName(source)}
+			if isVersionedNames(source) && !existing[currentKey] {
+				continue
+			}
+			r.writeTarget(ctx, pool, key, sourceContentHash(source), func() error {
+				return r.labelVersion(ctx, target, source, version, false, now)
+			})
+			r.versionGC.schedule(now.Add(retention))
+		} else if expiresAt := supersededAt.Add(retention); !now.Before(expiresAt) {
+			r.writeTarget(ctx, pool, key, "", func() error {
+				return r.deleteVersion(ctx, target, version)
+			})
+		} else {
+			r.versionGC.schedule(expiresAt)
+		}
+	}
+	return pool.Wait()
+}
+
+// labelVersion updates the current label and superseded-at annotation of version.
+func (r *SecretReconciler) labelVersion(ctx context.Context, target targetCluster, source, version *corev1.Secret,
+	current bool, supersededAt time.Time) error {
+	// Labels and annotations of immutable secrets can be updated
+	updated := version.DeepCopy()
+	if current {
+		updated.Labels[duplicatorCurrentLabelKey] = "true"
+		delete(updated.Annotations, duplicatorSupersededAtAnnotationKey)
+	} else {
+		updated.Labels[duplicatorCurrentLabelKey] = "false"
+		updated.Annotations[duplicatorSupersededAtAnnotationKey] = supersededAt.UTC().Format(time.RFC3339)
+	}
+	err := r.updateDuplicate(ctx, target, source, updated)
+	if err != nil {
+		return fmt.Errorf("label versioned copy %s of source %s: %w",
+			client.ObjectKeyFromObject(version), client.ObjectKeyFromObject(source), err)
+	}
+	return nil
+}
+
+// deleteVersion deletes a superseded or orphaned versioned copy.
+func (r *SecretReconciler) deleteVersion(ctx context.Context, target targetCluster, version *corev1.Secret) error {
+	err := r.deleteDuplicate(ctx, target, version)
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return fmt.Errorf("delete versioned copy %s: %w", client.ObjectKeyFromObject(version), err)
+	}
+	return nil
+}
+
+// versionGC tracks when the next superseded versioned copy expires, so that the reconcile is requeued to delete it.
+type versionGC struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// schedule requests a reconcile at expiresAt.
+func (g *versionGC) schedule(expiresAt time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.next.IsZero() || expiresAt.Before(g.next) {
+		g.next = expiresAt
+	}
+}
+
+// nextExpiry returns the delay until the next scheduled expiry, or zero if nothing is scheduled.
+// Every reconcile schedules the expiries of all superseded versioned copies again, so the schedule is reset.
+func (g *versionGC) nextExpiry(now time.Time) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.next.IsZero() {
+		return 0
+	}
+	delay := max(g.next.Sub(now), time.Second)
+	g.next = time.Time{}
+	return delay
+}
@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_SecretReconciler_reconcileVersions(t *testing.T) {
+	newSource := func(data string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "secret1",
+				Namespace: "ns1",
+				Annotations: map[string]string{
+					duplicatorDuplicateAnnotationKey:      "true",
+					duplicatorVersionedNamesAnnotationKey: "true",
+				},
+			},
+			Data: map[string][]byte{"foo": []byte(data)},
+		}
+	}
+	v1, v2, v3 := newSource("v1"), newSource("v2"), newSource("v3")
+	namespaces := []*corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "ns2"}},
+	}
+	orphan := (&SecretReconciler{}).newTargetVersionedCopy(targetCluster{}, newSource("v1"), "ns2")
+	orphan.Name = "deleted-0123456789"
+	orphan.Annotations[duplicatorFromAnnotationKey] = "ns1/deleted"
+	c := fake.NewClientBuilder().WithObjects(orphan).Build()
+	recorder := record.NewFakeRecorder(100)
+	r := &SecretReconciler{Client: c, Recorder: recorder, VersionRetention: time.Hour}
+
+	// reconcile syncs source into ns2 and returns the versioned copies in ns2 as name and current label
+	reconcile := func(source *corev1.Secret) map[string]string {
+		t.Helper()
+		allSecrets := &corev1.SecretList{}
+		if err := c.List(context.Background(), allSecrets); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, allVersions := partitionVersionedCopies(findAllDuplicateSecrets(allSecrets))
+		err := r.reconcileVersions(context.Background(), targetCluster{Client: c}, indexSecrets(allSecrets), allVersions,
+			[]*corev1.Secret{source}, namespaces, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := c.List(context.Background(), allSecrets, client.InNamespace("ns2")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		versions := make(map[string]string)
+		for _, secret := range allSecrets.Items {
+			versions[secret.Name] = secret.Labels[duplicatorCurrentLabelKey]
+		}
+		return versions
+	}
+	expire := func(name string) {
+		version := &corev1.Secret{}
+		if err := c.Get(context.Background(), client.ObjectKey{Namespace: "ns2", Name: name}, version); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		version.Annotations[duplicatorSupersededAtAnnotationKey] = time.Now().Add(-2 * time.Hour).Format(time.RFC3339)
+		if err := c.Update(context.Background(), version); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	edit := func(name string) {
+		version := &corev1.Secret{}
+		if err := c.Get(context.Background(), client.ObjectKey{Namespace: "ns2", Name: name}, version); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		version.Data = map[string][]byte{"foo": []byte("edited")}
+		if err := c.Update(context.Background(), version); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	collide := func(name string) {
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns2"}}
+		if err := c.Create(context.Background(), secret); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	steps := []struct {
+		name   string
+		source *corev1.Secret
+		before func()
+		want   map[string]string
+	}{
+		{
+			name:   "creates current version and deletes orphan",
+			source: v1,
+			want:   map[string]string{versionedName(v1): "true"},
+		},
+		{
+			name:   "creates new version, previous version stays current",
+			source: v2,
+			want:   map[string]string{versionedName(v1): "true", versionedName(v2): "true"},
+		},
+		{
+			name:   "supersedes previous version",
+			source: v2,
+			want:   map[string]string{versionedName(v1): "false", versionedName(v2): "true"},
+		},
+		{
+			name:   "revert makes superseded version current again",
+			source: v1,
+			want:   map[string]string{versionedName(v1): "true", versionedName(v2): "false"},
+		},
+		{
+			name:   "deletes expired version",
+			source: v1,
+			before: func() { expire(versionedName(v2)) },
+			want:   map[string]string{versionedName(v1): "true"},
+		},
+		{
+			name:   "recreates edited current version",
+			source: v1,
+			before: func() { edit(versionedName(v1)) },
+			want:   map[string]string{versionedName(v1): "true"},
+		},
+		{
+			name:   "doesn't overwrite secret with the name of the new version",
+			source: v3,
+			before: func() { collide(versionedName(v3)) },
+			want:   map[string]string{versionedName(v1): "true", versionedName(v3): ""},
+		},
+	}
+	for _, step := range steps {
+		if step.before != nil {
+			step.before()
+		}
+		if got := reconcile(step.source); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: got versions %v, wanted %v", step.name, got, step.want)
+		}
+	}
+
+	version := &corev1.Secret{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "ns2", Name: versionedName(v1)}, version); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(version.Data, v1.Data) {
+		t.Errorf("got data %v, wanted %v", version.Data, v1.Data)
+	}
+	if _, ok := version.Annotations[duplicatorSupersededAtAnnotationKey]; ok {
+		t.Errorf("current version has superseded-at annotation")
+	}
+	want := []string{fmt.Sprintf("Warning %s Duplicate can't be created: secret ns2/%s exists and isn't a duplicate",
+		reasonDuplicateConflict, versionedName(v3))}
+	if got := recordedEvents(recorder); !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %v, wanted %v", got, want)
+	}
+	wantConflicts := map[string]string{
+		"ns2/" + versionedName(v3): fmt.Sprintf("secret ns2/%s exists and isn't a duplicate", versionedName(v3)),
+	}
+	if got := r.conflicts.forSource("ns1/secret1", true); !reflect.DeepEqual(got, wantConflicts) {
+		t.Errorf("got version conflicts %v, wanted %v", got, wantConflicts)
+	}
+	if got := r.conflicts.forSource("ns1/secret1", false); got != nil {
+		t.Errorf("got conflicts %v, wanted none for duplicates", got)
+	}
+}
+
+func Test_versionGC(t *testing.T) {
+	now := time.Now()
+	var gc versionGC
+	if got := gc.nextExpiry(now); got != 0 {
+		t.Errorf("got %v without schedule, wanted 0", got)
+	}
+	for _, after := range []time.Duration{time.Hour, time.Minute, 2 * time.Hour} {
+		gc.schedule(now.Add(after))
+	}
+	if got := gc.nextExpiry(now); got != time.Minute {
+		t.Errorf("got %v, wanted %v", got, time.Minute)
+	}
+	if got := gc.nextExpiry(now); got != 0 {
+		t.Errorf("got %v after reset, wanted 0", got)
+	}
+	gc.schedule(now.Add(-time.Minute))
+	if got := gc.nextExpiry(now); got != time.Second {
+		t.Errorf("got %v for expired schedule, wanted %v", got, time.Second)
+	}
+}